@@ -0,0 +1,56 @@
+package amf
+
+import (
+	"reflect"
+	"sync"
+)
+
+var (
+	aliasMu     sync.RWMutex
+	aliasToType = make(map[string]reflect.Type)
+	typeToAlias = make(map[reflect.Type]string)
+)
+
+// RegisterClassAlias maps the ActionScript class alias to the Go type of v,
+// like registerClassAlias in ActionScript. Marshal writes the alias for
+// values of that type, and Unmarshal into an interface{} creates a pointer
+// to that type for objects carrying the alias.
+//
+// v must be a struct or a pointer to a struct. Registering an alias or a
+// type twice with different counterparts panics.
+func RegisterClassAlias(alias string, v interface{}) {
+	t := reflect.TypeOf(v)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		panic("amf: RegisterClassAlias needs a struct value")
+	}
+	if alias == "" {
+		panic("amf: RegisterClassAlias needs a non-empty alias")
+	}
+	aliasMu.Lock()
+	defer aliasMu.Unlock()
+	if old, ok := aliasToType[alias]; ok && old != t {
+		panic("amf: class alias " + alias + " registered for both " + old.String() + " and " + t.String())
+	}
+	if old, ok := typeToAlias[t]; ok && old != alias {
+		panic("amf: type " + t.String() + " registered as both " + old + " and " + alias)
+	}
+	aliasToType[alias] = t
+	typeToAlias[t] = alias
+}
+
+func typeByAlias(alias string) (reflect.Type, bool) {
+	aliasMu.RLock()
+	defer aliasMu.RUnlock()
+	t, ok := aliasToType[alias]
+	return t, ok
+}
+
+func aliasByType(t reflect.Type) (string, bool) {
+	aliasMu.RLock()
+	defer aliasMu.RUnlock()
+	alias, ok := typeToAlias[t]
+	return alias, ok
+}
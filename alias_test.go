@@ -0,0 +1,62 @@
+package amf
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+type testAliased struct {
+	ID int `amf:"id"`
+}
+
+func init() {
+	RegisterClassAlias("com.acme.User", testAliased{})
+}
+
+func TestMarshalClassAlias(t *testing.T) {
+	got, err := Marshal(testAliased{ID: 1}, AMF3)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	expect := []byte{0x0a, 0x13, 0x1b, 0x63, 0x6f, 0x6d, 0x2e, 0x61, 0x63, 0x6d, 0x65, 0x2e, 0x55, 0x73, 0x65, 0x72, 0x05, 0x69, 0x64, 0x04, 0x01}
+	if !bytes.Equal(expect, got) {
+		t.Errorf("expect %x got %x", expect, got)
+	}
+	got, err = Marshal(testAliased{ID: 1}, AMF0)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	expect = []byte{0x10, 0x00, 0x0d, 0x63, 0x6f, 0x6d, 0x2e, 0x61, 0x63, 0x6d, 0x65, 0x2e, 0x55, 0x73, 0x65, 0x72,
+		0x00, 0x02, 0x69, 0x64, 0x00, 0x3f, 0xf0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x09}
+	if !bytes.Equal(expect, got) {
+		t.Errorf("expect %x got %x", expect, got)
+	}
+}
+
+func TestUnmarshalClassAlias(t *testing.T) {
+	for _, version := range []int{AMF0, AMF3} {
+		data, err := Marshal([]interface{}{testAliased{ID: 7}}, version)
+		if err != nil {
+			t.Fatalf("version %d: %s", version, err)
+		}
+		var got interface{}
+		err = Unmarshal(data, &got, version)
+		if err != nil {
+			t.Fatalf("version %d: %s", version, err)
+		}
+		expect := []interface{}{&testAliased{ID: 7}}
+		if !reflect.DeepEqual(expect, got) {
+			t.Errorf("version %d: expect %v got %v", version, expect, got)
+		}
+	}
+}
+
+func TestRegisterClassAliasConflict(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("should panic on conflicting alias")
+		}
+	}()
+	RegisterClassAlias("com.acme.User", testUser{})
+}
@@ -2,23 +2,64 @@ package amf0
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
+	"github.com/hongruiqi/amf.go/amf3"
+	"github.com/hongruiqi/amf.go/internal/stream"
 	"io"
 	"math"
 )
 
 type Decoder struct {
-	r       io.Reader
-	refObjs []interface{}
+	r         *stream.Reader
+	buf       *bufio.Reader // buffering added to r, if any
+	refObjs   []interface{}
+	limits    DecoderLimits
+	depth     int         // nesting of the value being decoded
+	path      stream.Path // member names and item indexes leading to it
+	plain     bool
+	plainRefs map[interface{}]interface{} // wire objects already converted to plain values
 }
 
-// should use io.LimitedReader
+// NewDecoder returns a decoder reading from r. Readers that can read a byte
+// at a time, such as a bufio.Reader or a bytes.Reader, are used as is and
+// never read past the values decoded, so the input may go on with other
+// data. Other readers are buffered, and what was read ahead is left in
+// Buffered.
 func NewDecoder(r io.Reader) *Decoder {
-	if _, ok := r.(*bufio.Reader); ok {
-		return &Decoder{r: r}
+	dec := new(Decoder)
+	if _, ok := r.(io.ByteReader); !ok {
+		dec.buf = bufio.NewReader(r)
+		r = dec.buf
 	}
-	return &Decoder{r: bufio.NewReader(r)}
+	dec.r = &stream.Reader{R: r}
+	return dec
+}
+
+// Buffered returns the input read ahead of the values decoded so far. It is
+// empty when the decoder reads from r directly.
+func (dec *Decoder) Buffered() io.Reader {
+	if dec.buf == nil {
+		return bytes.NewReader(nil)
+	}
+	b, _ := dec.buf.Peek(dec.buf.Buffered())
+	return bytes.NewReader(b)
+}
+
+// InputOffset returns the number of input bytes taken by the values decoded
+// so far.
+func (dec *Decoder) InputOffset() int64 {
+	return dec.r.Off
+}
+
+// DecodeBytes decodes the value at the start of b and returns it with the
+// number of bytes it took. It reads b in place, without buffering.
+func DecodeBytes(b []byte) (v interface{}, n int, err error) {
+	dec := &Decoder{r: &stream.Reader{B: b}}
+	v, err = dec.Decode()
+	return v, int(dec.r.Off), err
 }
 
 func (dec *Decoder) Decode() (interface{}, error) {
@@ -26,22 +67,35 @@ func (dec *Decoder) Decode() (interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
+	if dec.plain {
+		return dec.toPlain(v), nil
+	}
 	return v, nil
 }
 
-func (dec *Decoder) decodeValue() (interface{}, error) {
-	u8 := make([]byte, 1)
-	u16 := make([]byte, 2)
-	u32 := make([]byte, 4)
-	u64 := make([]byte, 8)
-	_, err := dec.r.Read(u8)
+func (dec *Decoder) decodeValue() (_ interface{}, err error) {
+	offset := dec.r.Off
+	marker, err := dec.r.ReadByte()
 	if err != nil {
 		return nil, err
 	}
-	marker := u8[0]
+	dec.depth++
+	defer func() {
+		dec.depth--
+		// the stream may only end between values
+		if err == io.EOF {
+			err = ErrUnexpectedEOF
+		}
+		if _, ok := err.(*DecodeError); err != nil && !ok {
+			err = &DecodeError{Offset: offset, Marker: marker, Path: dec.path.String(), Err: err}
+		}
+	}()
+	if dec.limits.MaxDepth > 0 && dec.depth > dec.limits.MaxDepth {
+		return nil, fmt.Errorf("%w: depth %d", ErrLimitExceeded, dec.depth)
+	}
 	switch marker {
 	case NumberMarker:
-		_, err := dec.r.Read(u64)
+		u64, err := dec.r.Next(8)
 		if err != nil {
 			return nil, err
 		}
@@ -49,20 +103,23 @@ func (dec *Decoder) decodeValue() (interface{}, error) {
 		number := math.Float64frombits(u64n)
 		return NumberType(number), nil
 	case BooleanMarker:
-		_, err := dec.r.Read(u8)
+		b, err := dec.r.ReadByte()
 		if err != nil {
 			return nil, err
 		}
-		return BooleanType(u8[0] != 0), nil
+		return BooleanType(b != 0), nil
 	case StringMarker:
-		stringBytes, err := readUTF8(dec.r)
+		stringBytes, err := dec.readUTF8()
 		if err != nil {
 			return nil, err
 		}
 		return StringType(stringBytes), nil
 	case ObjectMarker:
 		object := new(ObjectType)
-		dec.refObjs = append(dec.refObjs, object)
+		err = dec.refObject(object)
+		if err != nil {
+			return nil, err
+		}
 		obj, err := dec.readObject()
 		if err != nil {
 			return nil, err
@@ -76,23 +133,30 @@ func (dec *Decoder) decodeValue() (interface{}, error) {
 	case UndefinedMarker:
 		return UndefinedType{}, nil
 	case ReferenceMarker:
-		_, err = dec.r.Read(u16)
+		u16, err := dec.r.Next(2)
 		if err != nil {
 			return nil, err
 		}
 		refid := binary.BigEndian.Uint16(u16)
 		if int(refid) >= len(dec.refObjs) {
-			return nil, errors.New("reference error")
+			return nil, fmt.Errorf("%w: reference %d outbound", ErrBadReference, refid)
 		}
 		return dec.refObjs[refid], nil
 	case EcmaArrayMarker:
-		_, err := dec.r.Read(u32)
+		u32, err := dec.r.Next(4)
 		if err != nil {
 			return nil, err
 		}
-		object := new(EcmaArrayType)
-		dec.refObjs = append(dec.refObjs, object)
 		associativeCount := binary.BigEndian.Uint32(u32)
+		object := new(EcmaArrayType)
+		err = dec.refObject(object)
+		if err != nil {
+			return nil, err
+		}
+		err = dec.checkCollectionLength(associativeCount)
+		if err != nil {
+			return nil, err
+		}
 		obj, err := dec.readObject()
 		if err != nil {
 			return nil, err
@@ -103,36 +167,44 @@ func (dec *Decoder) decodeValue() (interface{}, error) {
 		}
 		return object, nil
 	case StrictArrayMarker:
-		_, err := dec.r.Read(u32)
+		u32, err := dec.r.Next(4)
 		if err != nil {
 			return nil, err
 		}
-		object := new(StrictArrayType)
-		dec.refObjs = append(dec.refObjs, object)
 		arrayCount := binary.BigEndian.Uint32(u32)
-		array := make(StrictArrayType, arrayCount)
-		for i := 0; i < int(arrayCount); i++ {
-			array[i], err = dec.decodeValue()
+		object := new(StrictArrayType)
+		err = dec.refObject(object)
+		if err != nil {
+			return nil, err
+		}
+		err = dec.checkCollectionLength(arrayCount)
+		if err != nil {
+			return nil, err
+		}
+		array := make(StrictArrayType, 0, stream.CapHint(arrayCount))
+		for i := uint32(0); i < arrayCount; i++ {
+			value, err := dec.decodeItem(int(i))
 			if err != nil {
 				return nil, err
 			}
+			array = append(array, value)
 		}
 		*object = array
 		return object, nil
 	case DateMarker:
-		_, err := dec.r.Read(u64)
+		u64, err := dec.r.Next(8)
 		if err != nil {
 			return nil, err
 		}
 		u64n := binary.BigEndian.Uint64(u64)
 		date := math.Float64frombits(u64n)
-		_, err = dec.r.Read(u16)
+		_, err = dec.r.Next(2)
 		if err != nil {
 			return nil, err
 		}
 		return DateType{Date: date}, nil
 	case LongStringMarker:
-		stringBytes, err := readUTF8Long(dec.r)
+		stringBytes, err := dec.readUTF8Long()
 		if err != nil {
 			return nil, err
 		}
@@ -142,15 +214,18 @@ func (dec *Decoder) decodeValue() (interface{}, error) {
 	case RecordsetMarker:
 		return nil, errors.New("RecordSet Type not supported")
 	case XmlDocumentMarker:
-		stringBytes, err := readUTF8Long(dec.r)
+		stringBytes, err := dec.readUTF8Long()
 		if err != nil {
 			return nil, err
 		}
 		return XmlDocumentType(stringBytes), nil
 	case TypedObjectMarker:
 		object := new(TypedObjectType)
-		dec.refObjs = append(dec.refObjs, object)
-		classNameBytes, err := readUTF8(dec.r)
+		err = dec.refObject(object)
+		if err != nil {
+			return nil, err
+		}
+		classNameBytes, err := dec.readUTF8()
 		if err != nil {
 			return nil, err
 		}
@@ -160,31 +235,56 @@ func (dec *Decoder) decodeValue() (interface{}, error) {
 		}
 		*object = TypedObjectType{ClassName: StringType(classNameBytes), Object: _Object(obj)}
 		return object, nil
+	case AvmPlusObjectMarker:
+		start := dec.r.Off
+		v, err := dec.newAMF3Decoder().Decode()
+		if e, ok := err.(*amf3.DecodeError); ok {
+			return nil, dec.fromAMF3Error(e, start)
+		}
+		return v, err
 	}
-	panic("not reach")
-	return nil, nil
+	return nil, fmt.Errorf("%w 0x%02x", ErrUnknownMarker, marker)
+}
+
+// newAMF3Decoder returns the decoder for a value following AvmPlusObjectMarker.
+func (dec *Decoder) newAMF3Decoder() *amf3.Decoder {
+	dec3 := amf3.NewDecoder(dec.r)
+	limits := dec.limits
+	// the value already counts against our depth and byte budget
+	if limits.MaxDepth > 0 {
+		limits.MaxDepth -= dec.depth - 1
+	}
+	limits.MaxBytes = 0
+	dec3.SetLimits(limits)
+	if dec.plain {
+		dec3.UsePlainTypes()
+	}
+	return dec3
 }
 
 func (dec *Decoder) readObject() (_Object, error) {
-	u8 := make([]byte, 1)
 	v := make(map[StringType]interface{})
 	for {
-		name, err := readUTF8(dec.r)
+		name, err := dec.readUTF8()
 		if err != nil {
 			return nil, err
 		}
 		if name == "" {
-			_, err := dec.r.Read(u8)
+			b, err := dec.r.ReadByte()
 			if err != nil {
 				return nil, err
 			}
-			if u8[0] == ObjectEndMarker {
+			if b == ObjectEndMarker {
 				break
 			} else {
 				return nil, errors.New("expect ObjectEndMarker here")
 			}
 		}
-		value, err := dec.decodeValue()
+		err = dec.checkCollectionLength(uint32(len(v)) + 1)
+		if err != nil {
+			return nil, err
+		}
+		value, err := dec.decodeMember(name)
 		if err != nil {
 			return nil, err
 		}
@@ -196,9 +296,8 @@ func (dec *Decoder) readObject() (_Object, error) {
 	return v, nil
 }
 
-func readUTF8(r io.Reader) (StringType, error) {
-	u16 := make([]byte, 2)
-	_, err := r.Read(u16)
+func (dec *Decoder) readUTF8() (StringType, error) {
+	u16, err := dec.r.Next(2)
 	if err != nil {
 		return "", err
 	}
@@ -206,17 +305,15 @@ func readUTF8(r io.Reader) (StringType, error) {
 	if stringLength == 0 {
 		return "", nil
 	}
-	stringBytes := make([]byte, stringLength)
-	_, err = r.Read(stringBytes)
+	stringBytes, err := dec.readBytes(uint32(stringLength))
 	if err != nil {
 		return "", err
 	}
 	return StringType(stringBytes), nil
 }
 
-func readUTF8Long(r io.Reader) (LongStringType, error) {
-	u32 := make([]byte, 4)
-	_, err := r.Read(u32)
+func (dec *Decoder) readUTF8Long() (LongStringType, error) {
+	u32, err := dec.r.Next(4)
 	if err != nil {
 		return "", err
 	}
@@ -224,10 +321,17 @@ func readUTF8Long(r io.Reader) (LongStringType, error) {
 	if stringLength == 0 {
 		return "", nil
 	}
-	stringBytes := make([]byte, stringLength)
-	_, err = r.Read(stringBytes)
+	stringBytes, err := dec.readBytes(stringLength)
 	if err != nil {
 		return "", err
 	}
 	return LongStringType(stringBytes), nil
 }
+
+func (dec *Decoder) readBytes(n uint32) ([]byte, error) {
+	err := dec.checkStringLength(n)
+	if err != nil {
+		return nil, err
+	}
+	return dec.r.ReadBytes(n)
+}
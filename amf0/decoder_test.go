@@ -1,14 +1,21 @@
 package amf0
 
 import (
+	"bufio"
 	"bytes"
+	"errors"
+	"github.com/hongruiqi/amf.go/amf3"
+	"io"
+	"reflect"
+	"strings"
 	"testing"
+	"testing/iotest"
 )
 
 func TestReadUTF8(t *testing.T) {
 	buf := bytes.NewReader([]byte{0x00, 0x03, 'f', 'o', 'o'})
 	expect := StringType("foo")
-	got, err := readUTF8(buf)
+	got, err := NewDecoder(buf).readUTF8()
 	if err != nil {
 		t.Errorf("test for %s error: %s", "foo", err)
 	} else {
@@ -18,7 +25,7 @@ func TestReadUTF8(t *testing.T) {
 	}
 	buf = bytes.NewReader([]byte{0x00, 0x06, 0xe4, 0xbd, 0xa0, 0xe5, 0xa5, 0xbd})
 	expect = StringType("你好")
-	got, err = readUTF8(buf)
+	got, err = NewDecoder(buf).readUTF8()
 	if err != nil {
 		t.Errorf("test for %s error: %s", "你好", err)
 	} else {
@@ -31,7 +38,7 @@ func TestReadUTF8(t *testing.T) {
 func TestReadUTF8Long(t *testing.T) {
 	buf := bytes.NewReader([]byte{0x00, 0x00, 0x00, 0x03, 'f', 'o', 'o'})
 	expect := LongStringType("foo")
-	got, err := readUTF8Long(buf)
+	got, err := NewDecoder(buf).readUTF8Long()
 	if err != nil {
 		t.Errorf("test for %s error: %s", "foo", err)
 	} else {
@@ -41,7 +48,7 @@ func TestReadUTF8Long(t *testing.T) {
 	}
 	buf = bytes.NewReader([]byte{0x00, 0x00, 0x00, 0x06, 0xe4, 0xbd, 0xa0, 0xe5, 0xa5, 0xbd})
 	expect = LongStringType("你好")
-	got, err = readUTF8Long(buf)
+	got, err = NewDecoder(buf).readUTF8Long()
 	if err != nil {
 		t.Errorf("test for %s error: %s", "你好", err)
 	} else {
@@ -244,3 +251,282 @@ func TestDecodeTypedObject(t *testing.T) {
 		t.Fatalf("decode error")
 	}
 }
+
+func TestDecodeAvmPlusObject(t *testing.T) {
+	buf := bytes.NewReader([]byte{0x11, 0x06, 0x07, 0x66, 0x6f, 0x6f, 0x02, 0x00, 0x03, 0x62, 0x61, 0x72})
+	dec := NewDecoder(buf)
+	got, err := dec.Decode()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if got != amf3.StringType("foo") {
+		t.Fatalf("expect %v got %v", amf3.StringType("foo"), got)
+	}
+	got, err = dec.Decode()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if got != StringType("bar") {
+		t.Fatalf("expect %v got %v", StringType("bar"), got)
+	}
+}
+
+func TestDecodePlainTypes(t *testing.T) {
+	buf := bytes.NewReader([]byte{0x0a, 0x00, 0x00, 0x00, 0x04, 0x00, 0x40, 0x14, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0x00, 0x03, 0x66, 0x6f, 0x6f, 0x05,
+		0x03, 0x00, 0x04, 0x73, 0x65, 0x6c, 0x66, 0x07, 0x00, 0x01, 0x00, 0x00, 0x09})
+	dec := NewDecoder(buf)
+	dec.UsePlainTypes()
+	got, err := dec.Decode()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	array, ok := got.([]interface{})
+	if !ok || len(array) != 4 {
+		t.Fatalf("type incorrect %#v", got)
+	}
+	if array[0] != 5.0 || array[1] != "foo" || array[2] != nil {
+		t.Fatalf("decode incorrect %#v", array)
+	}
+	obj, ok := array[3].(map[string]interface{})
+	if !ok {
+		t.Fatalf("type incorrect %#v", array[3])
+	}
+	self, ok := obj["self"].(map[string]interface{})
+	if !ok || reflect.ValueOf(self).Pointer() != reflect.ValueOf(obj).Pointer() {
+		t.Fatalf("reference not kept")
+	}
+}
+
+func TestDecodeMalformed(t *testing.T) {
+	tests := []struct {
+		data []byte
+		err  error
+	}{
+		{[]byte{}, io.EOF},
+		{[]byte{0x13}, ErrUnknownMarker},
+		{[]byte{0x00}, ErrUnexpectedEOF},
+		{[]byte{0x03, 0x00, 0x01, 0x61}, ErrUnexpectedEOF},
+		{[]byte{0x0a, 0x00, 0x00, 0x00, 0x01}, ErrUnexpectedEOF},
+		{[]byte{0x07, 0x00, 0x00}, ErrBadReference},
+		{[]byte{0x11}, ErrUnexpectedEOF},
+		{[]byte{0x11, 0x20}, ErrUnknownMarker},
+		{[]byte{0x11, 0x0a, 0x02}, ErrBadReference},
+	}
+	for _, test := range tests {
+		got, err := NewDecoder(bytes.NewReader(test.data)).Decode()
+		if !errors.Is(err, test.err) {
+			t.Errorf("% x: expect error %v got %v", test.data, test.err, err)
+		}
+		if got != nil {
+			t.Errorf("% x: expect nil value got %#v", test.data, got)
+		}
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	f.Add([]byte{0x03, 0x00, 0x03, 0x66, 0x6f, 0x6f, 0x02, 0x00, 0x03, 0x62, 0x61, 0x72, 0x00, 0x00, 0x09})
+	f.Add([]byte{0x0a, 0x00, 0x00, 0x00, 0x02, 0x03, 0x00, 0x00, 0x09, 0x07, 0x00, 0x01})
+	f.Add([]byte{0x11, 0x06, 0x07, 0x66, 0x6f, 0x6f, 0x02, 0x00, 0x03, 0x62, 0x61, 0x72})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		dec := NewDecoder(bytes.NewReader(data))
+		for {
+			v, err := dec.Decode()
+			if err != nil {
+				break
+			}
+			if v == nil {
+				t.Fatalf("nil value without error")
+			}
+		}
+		dec = NewDecoder(bytes.NewReader(data))
+		dec.UsePlainTypes()
+		for {
+			_, err := dec.Decode()
+			if err != nil {
+				break
+			}
+		}
+	})
+}
+
+var fragmentedValues = []interface{}{
+	"foo",
+	strings.Repeat("long", 20000),
+	1.5,
+	true,
+	map[string]interface{}{"name": "foo", "items": []interface{}{1.0, "foo", false, nil}},
+	AvmPlusObjectType{Value: map[string]interface{}{"name": "bar", "items": []interface{}{1, "bar"}}},
+}
+
+// fragmenting readers split the input at every possible boundary, the way
+// a network connection may
+var fragmentingReaders = []struct {
+	name string
+	wrap func(io.Reader) io.Reader
+}{
+	{"OneByteReader", iotest.OneByteReader},
+	{"HalfReader", iotest.HalfReader},
+	{"DataErrReader", iotest.DataErrReader},
+}
+
+func TestDecodeFragmented(t *testing.T) {
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+	for _, v := range fragmentedValues {
+		err := enc.Encode(v)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+	data := buf.Bytes()
+	var expect []interface{}
+	dec := NewDecoder(bytes.NewReader(data))
+	dec.UsePlainTypes()
+	for range fragmentedValues {
+		v, err := dec.Decode()
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		expect = append(expect, v)
+	}
+	for _, fr := range fragmentingReaders {
+		dec := NewDecoder(fr.wrap(bytes.NewReader(data)))
+		dec.UsePlainTypes()
+		for i := range fragmentedValues {
+			got, err := dec.Decode()
+			if err != nil {
+				t.Fatalf("%s: value %d: %s", fr.name, i, err)
+			}
+			if !reflect.DeepEqual(got, expect[i]) {
+				t.Fatalf("%s: value %d: expect %#v got %#v", fr.name, i, expect[i], got)
+			}
+		}
+		_, err := dec.Decode()
+		if err != io.EOF {
+			t.Fatalf("%s: expect EOF got %v", fr.name, err)
+		}
+	}
+}
+
+func TestDecodeTruncated(t *testing.T) {
+	for _, v := range fragmentedValues {
+		buf := new(bytes.Buffer)
+		err := NewEncoder(buf).Encode(v)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		data := buf.Bytes()
+		for n := 1; n < len(data); n += 1 + n/16 {
+			_, err := NewDecoder(iotest.OneByteReader(bytes.NewReader(data[:n]))).Decode()
+			if !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Fatalf("% .16x truncated to %d bytes: expect %v got %v", data, n, io.ErrUnexpectedEOF, err)
+			}
+		}
+	}
+}
+
+func TestDecodeNoOverRead(t *testing.T) {
+	data := []byte{0x03, 0x00, 0x01, 0x61, 0x02, 0x00, 0x03, 0x66, 0x6f, 0x6f, 0x00, 0x00, 0x09, 0xde, 0xad, 0xbe, 0xef}
+	n := len(data) - 4
+	// readers able to read a byte at a time are read exactly
+	r := bufio.NewReader(bytes.NewReader(data))
+	dec := NewDecoder(r)
+	_, err := dec.Decode()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if dec.InputOffset() != int64(n) {
+		t.Fatalf("expect offset %d got %d", n, dec.InputOffset())
+	}
+	rest, _ := io.ReadAll(r)
+	if !bytes.Equal(rest, data[n:]) {
+		t.Fatalf("expect rest % x got % x", data[n:], rest)
+	}
+	// others leave what was read ahead in Buffered
+	src := struct{ io.Reader }{bytes.NewReader(data)}
+	dec = NewDecoder(src)
+	_, err = dec.Decode()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if dec.InputOffset() != int64(n) {
+		t.Fatalf("expect offset %d got %d", n, dec.InputOffset())
+	}
+	rest, _ = io.ReadAll(io.MultiReader(dec.Buffered(), src))
+	if !bytes.Equal(rest, data[n:]) {
+		t.Fatalf("expect rest % x got % x", data[n:], rest)
+	}
+}
+
+func TestDecodeBytes(t *testing.T) {
+	for i, v := range fragmentedValues {
+		buf := new(bytes.Buffer)
+		err := NewEncoder(buf).Encode(v)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		data := append(buf.Bytes(), 0xde, 0xad)
+		expect, err := NewDecoder(bytes.NewReader(data)).Decode()
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		got, n, err := DecodeBytes(data)
+		if err != nil {
+			t.Fatalf("value %d: %s", i, err)
+		}
+		if !reflect.DeepEqual(got, expect) {
+			t.Fatalf("value %d: expect %#v got %#v", i, expect, got)
+		}
+		if n != len(data)-2 {
+			t.Fatalf("value %d: expect %d bytes got %d", i, len(data)-2, n)
+		}
+		_, _, err = DecodeBytes(data[:n-1])
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Fatalf("value %d: expect %v got %v", i, io.ErrUnexpectedEOF, err)
+		}
+	}
+	_, n, err := DecodeBytes(nil)
+	if err != io.EOF || n != 0 {
+		t.Fatalf("expect EOF got %v after %d bytes", err, n)
+	}
+}
+
+// benchmarkValue is a typical RTMP command body.
+var benchmarkValue = []interface{}{"connect", 1.0, map[string]interface{}{
+	"app":         "live",
+	"tcUrl":       "rtmp://example.com/live",
+	"fpad":        false,
+	"audioCodecs": 3575.0,
+	"videoCodecs": 252.0,
+	"pageUrl":     nil,
+}}
+
+func BenchmarkDecode(b *testing.B) {
+	data, err := AppendEncode(nil, benchmarkValue)
+	if err != nil {
+		b.Fatalf("%s", err)
+	}
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, err := NewDecoder(bytes.NewReader(data)).Decode()
+		if err != nil {
+			b.Fatalf("%s", err)
+		}
+	}
+}
+
+func BenchmarkDecodeBytes(b *testing.B) {
+	data, err := AppendEncode(nil, benchmarkValue)
+	if err != nil {
+		b.Fatalf("%s", err)
+	}
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _, err := DecodeBytes(data)
+		if err != nil {
+			b.Fatalf("%s", err)
+		}
+	}
+}
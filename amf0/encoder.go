@@ -1,23 +1,45 @@
 package amf0
 
 import (
-	"bufio"
 	"encoding/binary"
 	"errors"
+	"fmt"
+	"github.com/hongruiqi/amf.go/amf3"
+	"github.com/hongruiqi/amf.go/internal/stream"
 	"io"
 	"math"
+	"reflect"
+	"time"
 )
 
 type Encoder struct {
 	w       io.Writer
-	bw      *bufio.Writer
-	refObjs []interface{}
+	bw      stream.Writer
+	refObjs map[interface{}]int
+	scratch [8]byte
 }
 
+// NewEncoder returns an encoder writing to w. Writers that take single
+// bytes and strings, such as a bufio.Writer, a bytes.Buffer or a
+// strings.Builder, are written to directly and flushing them is left to the
+// caller. Other writers are buffered, and Encode flushes the buffer after
+// each value.
 func NewEncoder(w io.Writer) *Encoder {
-	return &Encoder{w: w, bw: bufio.NewWriter(w)}
+	return &Encoder{w: w, bw: stream.NewWriter(w)}
 }
 
+// AppendEncode appends the encoding of v to dst and returns the extended
+// buffer. It writes into the slice directly, without buffering.
+func AppendEncode(dst []byte, v interface{}) ([]byte, error) {
+	w := &stream.SliceWriter{B: dst}
+	err := (&Encoder{bw: w}).Encode(v)
+	if err != nil {
+		return dst, err
+	}
+	return w.B, nil
+}
+
+// Encode writes v and flushes the output.
 func (enc *Encoder) Encode(v interface{}) error {
 	err := enc.encodeValue(v)
 	if err != nil {
@@ -27,17 +49,21 @@ func (enc *Encoder) Encode(v interface{}) error {
 	return err
 }
 
+// EncodeValue writes v without flushing the output, for values that are
+// part of a larger one, such as those written by a Marshaler.
+func (enc *Encoder) EncodeValue(v interface{}) error {
+	return enc.encodeValue(v)
+}
+
 func (enc *Encoder) encodeValue(v interface{}) error {
-	u32 := make([]byte, 4)
-	u64 := make([]byte, 8)
-	if value, ok := v.(NumberType); ok {
+	if value, ok := v.(Marshaler); ok {
+		return value.MarshalAMF0(enc)
+	} else if value, ok := v.(NumberType); ok {
 		err := enc.bw.WriteByte(NumberMarker)
 		if err != nil {
 			return err
 		}
-		number := math.Float64bits(float64(value))
-		binary.BigEndian.PutUint64(u64, number)
-		_, err = enc.bw.Write(u64)
+		err = enc.writeUint64(math.Float64bits(float64(value)))
 		if err != nil {
 			return err
 		}
@@ -59,7 +85,7 @@ func (enc *Encoder) encodeValue(v interface{}) error {
 		if err != nil {
 			return err
 		}
-		err = writeUTF8(enc.bw, value)
+		err = enc.writeUTF8(value)
 		if err != nil {
 			return err
 		}
@@ -71,7 +97,7 @@ func (enc *Encoder) encodeValue(v interface{}) error {
 		if ok {
 			return nil
 		} else {
-			enc.refObjs = append(enc.refObjs, value)
+			enc.refObject(value)
 			err := enc.bw.WriteByte(ObjectMarker)
 			if err != nil {
 				return err
@@ -99,14 +125,12 @@ func (enc *Encoder) encodeValue(v interface{}) error {
 		if ok {
 			return nil
 		} else {
-			enc.refObjs = append(enc.refObjs, value)
+			enc.refObject(value)
 			err := enc.bw.WriteByte(EcmaArrayMarker)
 			if err != nil {
 				return err
 			}
-			associativeCount := len(*value)
-			binary.BigEndian.PutUint32(u32, uint32(associativeCount))
-			_, err = enc.bw.Write(u32)
+			err = enc.writeUint32(uint32(len(*value)))
 			if err != nil {
 				return err
 			}
@@ -123,14 +147,13 @@ func (enc *Encoder) encodeValue(v interface{}) error {
 		if ok {
 			return nil
 		} else {
-			enc.refObjs = append(enc.refObjs, value)
+			enc.refObject(value)
 			err := enc.bw.WriteByte(StrictArrayMarker)
 			if err != nil {
 				return err
 			}
 			arrayCount := len(*value)
-			binary.BigEndian.PutUint32(u32, uint32(arrayCount))
-			_, err = enc.bw.Write(u32)
+			err = enc.writeUint32(uint32(arrayCount))
 			if err != nil {
 				return err
 			}
@@ -146,13 +169,11 @@ func (enc *Encoder) encodeValue(v interface{}) error {
 		if err != nil {
 			return err
 		}
-		date := math.Float64bits(value.Date)
-		binary.BigEndian.PutUint64(u64, date)
-		_, err = enc.bw.Write(u64)
+		err = enc.writeUint64(math.Float64bits(value.Date))
 		if err != nil {
 			return err
 		}
-		_, err = enc.bw.Write([]byte{0x00, 0x00})
+		err = enc.writeUint16(0) // time zone
 		if err != nil {
 			return err
 		}
@@ -161,7 +182,7 @@ func (enc *Encoder) encodeValue(v interface{}) error {
 		if err != nil {
 			return err
 		}
-		err = writeUTF8Long(enc.bw, v.(LongStringType))
+		err = enc.writeUTF8Long(v.(LongStringType))
 		if err != nil {
 			return err
 		}
@@ -175,7 +196,7 @@ func (enc *Encoder) encodeValue(v interface{}) error {
 		if err != nil {
 			return err
 		}
-		err = writeUTF8Long(enc.bw, LongStringType(value))
+		err = enc.writeUTF8Long(LongStringType(value))
 		if err != nil {
 			return err
 		}
@@ -185,12 +206,12 @@ func (enc *Encoder) encodeValue(v interface{}) error {
 			return err
 		}
 		if !ok {
-			enc.refObjs = append(enc.refObjs, value)
+			enc.refObject(value)
 			err := enc.bw.WriteByte(TypedObjectMarker)
 			if err != nil {
 				return err
 			}
-			err = writeUTF8(enc.bw, value.ClassName)
+			err = enc.writeUTF8(value.ClassName)
 			if err != nil {
 				return err
 			}
@@ -199,34 +220,165 @@ func (enc *Encoder) encodeValue(v interface{}) error {
 				return err
 			}
 		}
+	} else if value, ok := v.(AvmPlusObjectType); ok {
+		err := enc.bw.WriteByte(AvmPlusObjectMarker)
+		if err != nil {
+			return err
+		}
+		// written straight into our buffer, with reference tables of its own
+		err = amf3.NewEncoder(enc.bw).EncodeValue(value.Value)
+		if err != nil {
+			return err
+		}
+	} else if v == nil {
+		err := enc.bw.WriteByte(NullMarker)
+		if err != nil {
+			return err
+		}
+	} else if value, ok := v.(string); ok {
+		if len(value) > 0xFFFF {
+			return enc.encodeValue(LongStringType(value))
+		}
+		return enc.encodeValue(StringType(value))
+	} else if value, ok := v.(bool); ok {
+		return enc.encodeValue(BooleanType(value))
+	} else if value, ok := toNumber(v); ok {
+		return enc.encodeValue(value)
+	} else if value, ok := v.(time.Time); ok {
+		return enc.encodeValue(DateType{Date: float64(amf3.NewDate(value))})
+	} else if value, ok := v.(map[string]interface{}); ok {
+		if value == nil {
+			return enc.encodeValue(nil)
+		}
+		key := stream.MapRef(reflect.ValueOf(value))
+		ok, err := enc.writeRef(key)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			enc.refObject(key)
+			err := enc.bw.WriteByte(ObjectMarker)
+			if err != nil {
+				return err
+			}
+			obj := make(_Object, len(value))
+			for k, v := range value {
+				obj[StringType(k)] = v
+			}
+			err = enc.writeObject(obj)
+			if err != nil {
+				return err
+			}
+		}
+	} else if value, ok := v.([]interface{}); ok {
+		if value == nil {
+			return enc.encodeValue(nil)
+		}
+		key := stream.SliceRef(reflect.ValueOf(value), len(enc.refObjs))
+		ok, err := enc.writeRef(key)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			enc.refObject(key)
+			err := enc.bw.WriteByte(StrictArrayMarker)
+			if err != nil {
+				return err
+			}
+			err = enc.writeUint32(uint32(len(value)))
+			if err != nil {
+				return err
+			}
+			for _, item := range value {
+				err := enc.encodeValue(item)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	} else if isAMF3Value(v) {
+		return enc.encodeValue(AvmPlusObjectType{Value: v})
 	} else {
-		return errors.New("unsupported type")
+		return fmt.Errorf("amf0: unsupported type %T", v)
 	}
 	return nil
 }
 
+var amf3PkgPath = reflect.TypeOf(amf3.NullType{}).PkgPath()
+
+// isAMF3Value reports whether v is an amf3 wire type or an amf3.Marshaler,
+// such as the values the Decoder reads after AvmPlusObjectMarker, which are
+// switched back to AMF3 the same way.
+func isAMF3Value(v interface{}) bool {
+	if _, ok := v.(amf3.Marshaler); ok {
+		return true
+	}
+	t := reflect.TypeOf(v)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.PkgPath() == amf3PkgPath
+}
+
+func toNumber(v interface{}) (NumberType, bool) {
+	switch n := v.(type) {
+	case float64:
+		return NumberType(n), true
+	case float32:
+		return NumberType(n), true
+	case int:
+		return NumberType(n), true
+	case int8:
+		return NumberType(n), true
+	case int16:
+		return NumberType(n), true
+	case int32:
+		return NumberType(n), true
+	case int64:
+		return NumberType(n), true
+	case uint:
+		return NumberType(n), true
+	case uint8:
+		return NumberType(n), true
+	case uint16:
+		return NumberType(n), true
+	case uint32:
+		return NumberType(n), true
+	case uint64:
+		return NumberType(n), true
+	}
+	return 0, false
+}
+
+// writeRef writes a reference to v if v was written before. References
+// hold 16 bits, so objects past the first 65536 are always written in full.
 func (enc *Encoder) writeRef(v interface{}) (bool, error) {
-	u16 := make([]byte, 2)
-	for i, obj := range enc.refObjs {
-		if v == obj {
-			err := enc.bw.WriteByte(ReferenceMarker)
-			if err != nil {
-				return false, err
-			}
-			binary.BigEndian.PutUint16(u16, uint16(i))
-			_, err = enc.bw.Write(u16)
-			if err != nil {
-				return false, err
-			}
-			return true, nil
-		}
+	i, ok := enc.refObjs[v]
+	if !ok || i > 0xFFFF {
+		return false, nil
+	}
+	err := enc.bw.WriteByte(ReferenceMarker)
+	if err != nil {
+		return false, err
+	}
+	err = enc.writeUint16(uint16(i))
+	if err != nil {
+		return false, err
 	}
-	return false, nil
+	return true, nil
+}
+
+// refObject adds v to the reference table.
+func (enc *Encoder) refObject(v interface{}) {
+	if enc.refObjs == nil {
+		enc.refObjs = make(map[interface{}]int)
+	}
+	enc.refObjs[v] = len(enc.refObjs)
 }
 
 func (enc *Encoder) writeObject(obj _Object) error {
 	for k, v := range obj {
-		err := writeUTF8(enc.bw, k)
+		err := enc.writeUTF8(k)
 		if err != nil {
 			return err
 		}
@@ -235,42 +387,55 @@ func (enc *Encoder) writeObject(obj _Object) error {
 			return err
 		}
 	}
-	_, err := enc.bw.Write([]byte{0x00, 0x00, ObjectEndMarker})
+	err := enc.writeUint16(0)
 	if err != nil {
 		return err
 	}
-	return nil
+	return enc.bw.WriteByte(ObjectEndMarker)
 }
 
-func writeUTF8(w io.Writer, s StringType) error {
-	u16 := make([]byte, 2)
+func (enc *Encoder) writeUTF8(s StringType) error {
 	length := len(s)
 	if length > 0xFFFF {
 		return errors.New("string too long")
 	}
-	binary.BigEndian.PutUint16(u16, uint16(length))
-	_, err := w.Write(u16)
+	err := enc.writeUint16(uint16(length))
 	if err != nil {
 		return err
 	}
-	_, err = w.Write([]byte(s))
+	_, err = enc.bw.WriteString(string(s))
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func writeUTF8Long(w io.Writer, s LongStringType) error {
-	u32 := make([]byte, 4)
-	length := len(s)
-	binary.BigEndian.PutUint32(u32, uint32(length))
-	_, err := w.Write(u32)
+func (enc *Encoder) writeUTF8Long(s LongStringType) error {
+	err := enc.writeUint32(uint32(len(s)))
 	if err != nil {
 		return err
 	}
-	_, err = w.Write([]byte(s))
+	_, err = enc.bw.WriteString(string(s))
 	if err != nil {
 		return err
 	}
 	return nil
 }
+
+func (enc *Encoder) writeUint16(n uint16) error {
+	binary.BigEndian.PutUint16(enc.scratch[:2], n)
+	_, err := enc.bw.Write(enc.scratch[:2])
+	return err
+}
+
+func (enc *Encoder) writeUint32(n uint32) error {
+	binary.BigEndian.PutUint32(enc.scratch[:4], n)
+	_, err := enc.bw.Write(enc.scratch[:4])
+	return err
+}
+
+func (enc *Encoder) writeUint64(n uint64) error {
+	binary.BigEndian.PutUint64(enc.scratch[:], n)
+	_, err := enc.bw.Write(enc.scratch[:])
+	return err
+}
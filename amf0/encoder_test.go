@@ -1,29 +1,37 @@
 package amf0
 
 import (
+	"bufio"
 	"bytes"
+	"github.com/hongruiqi/amf.go/amf3"
+	"github.com/hongruiqi/amf.go/internal/stream"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestWriteUTF8(t *testing.T) {
-	buf := new(bytes.Buffer)
-	err := writeUTF8(buf, "foo")
+	buf := new(stream.SliceWriter)
+	err := (&Encoder{bw: buf}).writeUTF8("foo")
 	if err != nil {
 		t.Errorf("test for %s error: %s", "foo", err)
 	} else {
 		expect := []byte{0x00, 0x03, 'f', 'o', 'o'}
-		got := buf.Bytes()
+		got := buf.B
 		if !bytes.Equal(expect, got) {
 			t.Errorf("expect %x got %x", expect, got)
 		}
 	}
-	buf = new(bytes.Buffer)
-	err = writeUTF8(buf, "你好")
+	buf = new(stream.SliceWriter)
+	err = (&Encoder{bw: buf}).writeUTF8("你好")
 	if err != nil {
 		t.Errorf("test for %s error: %s", "你好", err)
 	} else {
 		expect := []byte{0x00, 0x06, 0xe4, 0xbd, 0xa0, 0xe5, 0xa5, 0xbd}
-		got := buf.Bytes()
+		got := buf.B
 		if !bytes.Equal(expect, got) {
 			t.Errorf("expect %x got %x", expect, got)
 		}
@@ -31,24 +39,24 @@ func TestWriteUTF8(t *testing.T) {
 }
 
 func TestWriteUTF8Long(t *testing.T) {
-	buf := new(bytes.Buffer)
-	err := writeUTF8Long(buf, "foo")
+	buf := new(stream.SliceWriter)
+	err := (&Encoder{bw: buf}).writeUTF8Long("foo")
 	if err != nil {
 		t.Errorf("test for %s error: %s", "foo", err)
 	} else {
 		expect := []byte{0x00, 0x00, 0x00, 0x03, 'f', 'o', 'o'}
-		got := buf.Bytes()
+		got := buf.B
 		if !bytes.Equal(expect, got) {
 			t.Errorf("expect %x got %x", expect, got)
 		}
 	}
-	buf = new(bytes.Buffer)
-	err = writeUTF8Long(buf, "你好")
+	buf = new(stream.SliceWriter)
+	err = (&Encoder{bw: buf}).writeUTF8Long("你好")
 	if err != nil {
 		t.Errorf("test for %s error: %s", "你好", err)
 	} else {
 		expect := []byte{0x00, 0x00, 0x00, 0x06, 0xe4, 0xbd, 0xa0, 0xe5, 0xa5, 0xbd}
-		got := buf.Bytes()
+		got := buf.B
 		if !bytes.Equal(expect, got) {
 			t.Errorf("expect %x got %x", expect, got)
 		}
@@ -264,3 +272,260 @@ func TestEncodeTypedObject(t *testing.T) {
 		t.Errorf("expect %x got %x", expect, got)
 	}
 }
+
+func TestEncodeAvmPlusObject(t *testing.T) {
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+	err := enc.Encode(AvmPlusObjectType{Value: amf3.StringType("foo")})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	expect := []byte{0x11, 0x06, 0x07, 0x66, 0x6f, 0x6f}
+	got := buf.Bytes()
+	if !bytes.Equal(expect, got) {
+		t.Errorf("expect %x got %x", expect, got)
+	}
+}
+
+func TestEncodeAMF3Value(t *testing.T) {
+	got, err := AppendEncode(nil, &StrictArrayType{amf3.StringType("foo")})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	// as read from a Flash request, each item switching to AMF3 on its own
+	expect := []byte{0x0a, 0x00, 0x00, 0x00, 0x01, 0x11, 0x06, 0x07, 0x66, 0x6f, 0x6f}
+	if !bytes.Equal(expect, got) {
+		t.Errorf("expect %x got %x", expect, got)
+	}
+}
+
+func TestEncodeAvmPlusObjectBuffered(t *testing.T) {
+	buf := new(bytes.Buffer)
+	bw := bufio.NewWriter(buf)
+	err := NewEncoder(bw).Encode(AvmPlusObjectType{Value: []interface{}{amf3.StringType("foo")}})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	// the value is written into bw as is, and left there to be flushed
+	if buf.Len() != 0 || bw.Buffered() != 9 {
+		t.Errorf("expect 9 bytes in the buffer got %d, and %d written through", bw.Buffered(), buf.Len())
+	}
+}
+
+func TestEncodeNative(t *testing.T) {
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+	err := enc.Encode([]interface{}{"foo", 5, true, nil, time.Unix(0, 5000000)})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	expect := []byte{0x0a, 0x00, 0x00, 0x00, 0x05, 0x02, 0x00, 0x03, 0x66, 0x6f, 0x6f, 0x00, 0x40, 0x14, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x01, 0x01, 0x05, 0x0b, 0x40, 0x14, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+	got := buf.Bytes()
+	if !bytes.Equal(expect, got) {
+		t.Errorf("expect %x got %x", expect, got)
+	}
+}
+
+func TestEncodeNativeMapReference(t *testing.T) {
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+	m := map[string]interface{}{}
+	m["foo"] = m
+	err := enc.Encode(m)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	expect := []byte{0x03, 0x00, 0x03, 0x66, 0x6f, 0x6f, 0x07, 0x00, 0x00, 0x00, 0x00, 0x09}
+	got := buf.Bytes()
+	if !bytes.Equal(expect, got) {
+		t.Errorf("expect %x got %x", expect, got)
+	}
+}
+
+func TestEncodeEmptySlices(t *testing.T) {
+	s := []interface{}{true}
+	data, err := AppendEncode(nil, []interface{}{[]interface{}{}, []interface{}{}, s, s})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	expect := []byte{0x0a, 0x00, 0x00, 0x00, 0x04,
+		0x0a, 0x00, 0x00, 0x00, 0x00, // each empty slice is an array of its own
+		0x0a, 0x00, 0x00, 0x00, 0x00,
+		0x0a, 0x00, 0x00, 0x00, 0x01, 0x01, 0x01,
+		0x07, 0x00, 0x03} // and still counts in the reference table
+	if !bytes.Equal(expect, data) {
+		t.Errorf("expect %x got %x", expect, data)
+	}
+}
+
+func TestEncodeUnsupported(t *testing.T) {
+	enc := NewEncoder(new(bytes.Buffer))
+	err := enc.Encode(struct{}{})
+	if err == nil || err.Error() != "amf0: unsupported type struct {}" {
+		t.Errorf("should report unsupported type, got %v", err)
+	}
+}
+
+func TestEncodeNativeReferencesAcrossGC(t *testing.T) {
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+	for i := 0; i < 100; i++ {
+		buf.Reset()
+		err := enc.Encode([]interface{}{NumberType(i)})
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		// a slice written before must not be mistaken for a new one that
+		// took its address
+		if buf.Bytes()[0] == ReferenceMarker {
+			t.Fatalf("expect a new array got %x", buf.Bytes())
+		}
+		runtime.GC()
+	}
+}
+
+func TestEncodeNativeLongString(t *testing.T) {
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+	err := enc.Encode(strings.Repeat("a", 0x10000))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	got := buf.Bytes()
+	expect := []byte{0x0c, 0x00, 0x01, 0x00, 0x00}
+	if !bytes.Equal(expect, got[:5]) || len(got) != 5+0x10000 {
+		t.Errorf("expect prefix %x got %x", expect, got[:5])
+	}
+}
+
+type testPoint struct {
+	x, y float64
+}
+
+func (p testPoint) MarshalAMF0(enc *Encoder) error {
+	return enc.EncodeValue(&StrictArrayType{NumberType(p.x), NumberType(p.y)})
+}
+
+func TestEncodeMarshaler(t *testing.T) {
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+	err := enc.Encode(&StrictArrayType{testPoint{1, 2}})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	expect := []byte{0x0a, 0x00, 0x00, 0x00, 0x01, 0x0a, 0x00, 0x00, 0x00, 0x02,
+		0x00, 0x3f, 0xf0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x40, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+	got := buf.Bytes()
+	if !bytes.Equal(expect, got) {
+		t.Errorf("expect %x got %x", expect, got)
+	}
+}
+
+func TestAppendEncode(t *testing.T) {
+	for _, v := range fragmentedValues {
+		buf := new(bytes.Buffer)
+		err := NewEncoder(buf).Encode(v)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		prefix := []byte{0xca, 0xfe}
+		got, err := AppendEncode(prefix, v)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		if !bytes.Equal(got[:2], prefix) || len(got) != len(prefix)+buf.Len() {
+			t.Fatalf("expect % .32x after prefix got % .32x", buf.Bytes(), got)
+		}
+		// maps may come out in any order, so compare what decodes back
+		expect, err := NewDecoder(buf).Decode()
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		value, _, err := DecodeBytes(got[2:])
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		if !reflect.DeepEqual(value, expect) {
+			t.Fatalf("expect %#v got %#v", expect, value)
+		}
+	}
+}
+
+func TestEncodeManyReferences(t *testing.T) {
+	const n = 70000
+	array := make(StrictArrayType, n, n+2)
+	for i := range array {
+		array[i] = &ObjectType{"i": NumberType(i)}
+	}
+	// the array itself takes reference 0, so only the first 0xFFFF objects
+	// can be referred to
+	array = append(array, array[0], array[n-1])
+	data, err := AppendEncode(nil, &array)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	v, _, err := DecodeBytes(data)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	got := *v.(*StrictArrayType)
+	if got[n] != got[0] {
+		t.Errorf("item %d is not a reference to item 0", n)
+	}
+	if got[n+1] == got[n-1] || !reflect.DeepEqual(got[n+1], got[n-1]) {
+		t.Errorf("item %d is not a copy of item %d", n+1, n-1)
+	}
+}
+
+func BenchmarkEncode(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf := new(bytes.Buffer)
+		err := NewEncoder(buf).Encode(benchmarkValue)
+		if err != nil {
+			b.Fatalf("%s", err)
+		}
+	}
+}
+
+func BenchmarkAppendEncode(b *testing.B) {
+	b.ReportAllocs()
+	var buf []byte
+	for i := 0; i < b.N; i++ {
+		var err error
+		buf, err = AppendEncode(buf[:0], benchmarkValue)
+		if err != nil {
+			b.Fatalf("%s", err)
+		}
+	}
+}
+
+// benchmarkEncodeLarge encodes arrays of n objects for several n; the time
+// per item stays flat as the reference table grows.
+func benchmarkEncodeLarge(b *testing.B, item func(i int) interface{}) {
+	for _, n := range []int{1000, 10000, 100000} {
+		array := make([]interface{}, n)
+		for i := range array {
+			array[i] = item(i)
+		}
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			b.ReportAllocs()
+			var buf []byte
+			for i := 0; i < b.N; i++ {
+				var err error
+				buf, err = AppendEncode(buf[:0], array)
+				if err != nil {
+					b.Fatalf("%s", err)
+				}
+			}
+			b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*n), "ns/item")
+		})
+	}
+}
+
+func BenchmarkEncodeObjects(b *testing.B) {
+	benchmarkEncodeLarge(b, func(i int) interface{} {
+		return &ObjectType{"id": NumberType(i), "name": StringType("item")}
+	})
+}
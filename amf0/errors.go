@@ -0,0 +1,64 @@
+package amf0
+
+import (
+	"fmt"
+	"github.com/hongruiqi/amf.go/amf3"
+)
+
+// Errors returned by Decoder for malformed input. They are usually wrapped
+// with more detail, so test for them with errors.Is. They are the same values
+// as in amf3, so errors from AVM+ values match them too.
+var (
+	ErrUnknownMarker = amf3.ErrUnknownMarker
+	ErrBadReference  = amf3.ErrBadReference
+	ErrUnexpectedEOF = amf3.ErrUnexpectedEOF
+	ErrLimitExceeded = amf3.ErrLimitExceeded
+)
+
+// DecodeError reports where in the input a Decoder failed. Failures inside
+// values switched to AMF3 are reported with their offset and path in the
+// whole AMF0 input.
+type DecodeError struct {
+	Offset int64  // offset of the value that failed, from where the decoder started
+	Marker byte   // marker of the value that failed
+	Path   string // member names and item indexes leading to the value, as in users[2].roles
+	Err    error  // the cause
+}
+
+func (e *DecodeError) Error() string {
+	s := fmt.Sprintf("amf0: offset %d, marker 0x%02x", e.Offset, e.Marker)
+	if e.Path != "" {
+		s += ", path " + e.Path
+	}
+	return s + ": " + e.Err.Error()
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// decodeMember decodes the value of the member name, keeping track of the path.
+func (dec *Decoder) decodeMember(name StringType) (interface{}, error) {
+	dec.path.PushMember(string(name))
+	v, err := dec.decodeValue()
+	dec.path.Pop()
+	return v, err
+}
+
+// decodeItem decodes the item at index i, keeping track of the path.
+func (dec *Decoder) decodeItem(i int) (interface{}, error) {
+	dec.path.PushItem(i)
+	v, err := dec.decodeValue()
+	dec.path.Pop()
+	return v, err
+}
+
+// fromAMF3Error moves e, from the AVM+ value starting at offset start, into
+// the offsets and path of the AMF0 input.
+func (dec *Decoder) fromAMF3Error(e *amf3.DecodeError, start int64) *DecodeError {
+	path := dec.path.String()
+	if path != "" && e.Path != "" && e.Path[0] != '[' {
+		path += "."
+	}
+	return &DecodeError{Offset: start + e.Offset, Marker: e.Marker, Path: path + e.Path, Err: e.Err}
+}
@@ -0,0 +1,33 @@
+package amf0
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestDecodeErrorPath(t *testing.T) {
+	tests := []struct {
+		data   []byte
+		offset int64
+		marker byte
+		path   string
+	}{
+		{[]byte{0x0a, 0x00, 0x00, 0x00, 0x01, 0x03, 0x00, 0x04, 0x75, 0x73, 0x65, 0x72, 0x03, 0x00, 0x05, 0x72, 0x6f, 0x6c, 0x65, 0x73,
+			0x0a, 0x00, 0x00, 0x00, 0x02, 0x05, 0x07, 0x00, 0x09}, 26, ReferenceMarker, "[0].user.roles[1]"},
+		{[]byte{0x0a, 0x00, 0x00, 0x00, 0x01, 0x11, 0x0a, 0x0b, 0x01, 0x03, 0x61, 0x09, 0x05, 0x01, 0x04, 0x01, 0x0a, 0x04}, 16, 0x0a, "[0].a[1]"},
+	}
+	for _, test := range tests {
+		_, err := NewDecoder(bytes.NewReader(test.data)).Decode()
+		var e *DecodeError
+		if !errors.As(err, &e) {
+			t.Fatalf("expect DecodeError got %v", err)
+		}
+		if e.Offset != test.offset || e.Marker != test.marker || e.Path != test.path {
+			t.Fatalf("decode error incorrect %+v", e)
+		}
+		if !errors.Is(err, ErrBadReference) {
+			t.Fatalf("expect %v got %v", ErrBadReference, e.Err)
+		}
+	}
+}
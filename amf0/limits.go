@@ -0,0 +1,42 @@
+package amf0
+
+import (
+	"fmt"
+	"github.com/hongruiqi/amf.go/amf3"
+)
+
+// DecoderLimits bounds the resources a Decoder spends on untrusted input.
+// It is the same type as in amf3; the limits carry over to values switched
+// to AMF3, which also count against the depth of the AMF0 value around
+// them and the byte budget of the Decoder.
+type DecoderLimits = amf3.DecoderLimits
+
+// SetLimits makes the decoder fail with ErrLimitExceeded when the input
+// goes past limits.
+func (dec *Decoder) SetLimits(limits DecoderLimits) {
+	dec.limits = limits
+	dec.r.Limit = limits.MaxBytes
+}
+
+func (dec *Decoder) checkStringLength(n uint32) error {
+	if dec.limits.MaxStringLength > 0 && uint64(n) > uint64(dec.limits.MaxStringLength) {
+		return fmt.Errorf("%w: string length %d", ErrLimitExceeded, n)
+	}
+	return nil
+}
+
+func (dec *Decoder) checkCollectionLength(n uint32) error {
+	if dec.limits.MaxCollectionLength > 0 && uint64(n) > uint64(dec.limits.MaxCollectionLength) {
+		return fmt.Errorf("%w: collection length %d", ErrLimitExceeded, n)
+	}
+	return nil
+}
+
+// refObject adds obj to the reference table.
+func (dec *Decoder) refObject(obj interface{}) error {
+	if dec.limits.MaxReferences > 0 && len(dec.refObjs) >= dec.limits.MaxReferences {
+		return fmt.Errorf("%w: more than %d references", ErrLimitExceeded, dec.limits.MaxReferences)
+	}
+	dec.refObjs = append(dec.refObjs, obj)
+	return nil
+}
@@ -0,0 +1,57 @@
+package amf0
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestDecoderLimits(t *testing.T) {
+	avmPlus := []byte{0x0a, 0x00, 0x00, 0x00, 0x01, 0x11, 0x09, 0x03, 0x01, 0x09, 0x01, 0x01}
+	object := []byte{0x03, 0x00, 0x01, 0x61, 0x05, 0x00, 0x01, 0x62, 0x05, 0x00, 0x00, 0x09}
+	tests := []struct {
+		data   []byte
+		limits DecoderLimits
+		err    error
+	}{
+		{avmPlus, DecoderLimits{MaxDepth: 3}, nil},
+		{avmPlus, DecoderLimits{MaxDepth: 2}, ErrLimitExceeded},
+		{avmPlus, DecoderLimits{MaxBytes: 12}, nil},
+		{avmPlus, DecoderLimits{MaxBytes: 11}, ErrLimitExceeded},
+		{[]byte{0x02, 0x00, 0x03, 0x66, 0x6f, 0x6f}, DecoderLimits{MaxStringLength: 3}, nil},
+		{[]byte{0x02, 0x00, 0x03, 0x66, 0x6f, 0x6f}, DecoderLimits{MaxStringLength: 2}, ErrLimitExceeded},
+		{[]byte{0x0c, 0xff, 0xff, 0xff, 0xff}, DecoderLimits{MaxStringLength: 1024}, ErrLimitExceeded},
+		{[]byte{0x0c, 0xff, 0xff, 0xff, 0xff}, DecoderLimits{MaxBytes: 1024}, ErrLimitExceeded},
+		{[]byte{0x0a, 0xff, 0xff, 0xff, 0xff}, DecoderLimits{MaxCollectionLength: 1024}, ErrLimitExceeded},
+		{object, DecoderLimits{MaxCollectionLength: 2}, nil},
+		{object, DecoderLimits{MaxCollectionLength: 1}, ErrLimitExceeded},
+		{[]byte{0x0a, 0x00, 0x00, 0x00, 0x02, 0x03, 0x00, 0x00, 0x09, 0x03, 0x00, 0x00, 0x09}, DecoderLimits{MaxReferences: 3}, nil},
+		{[]byte{0x0a, 0x00, 0x00, 0x00, 0x02, 0x03, 0x00, 0x00, 0x09, 0x03, 0x00, 0x00, 0x09}, DecoderLimits{MaxReferences: 2}, ErrLimitExceeded},
+	}
+	for _, test := range tests {
+		dec := NewDecoder(bytes.NewReader(test.data))
+		dec.SetLimits(test.limits)
+		_, err := dec.Decode()
+		if !errors.Is(err, test.err) {
+			t.Errorf("% x with %+v: expect error %v got %v", test.data, test.limits, test.err, err)
+		}
+	}
+}
+
+func TestDecoderMaxBytesTotal(t *testing.T) {
+	buf := bytes.NewReader([]byte{0x02, 0x00, 0x03, 0x66, 0x6f, 0x6f, 0x11, 0x06, 0x07, 0x62, 0x61, 0x72})
+	dec := NewDecoder(buf)
+	dec.SetLimits(DecoderLimits{MaxBytes: 10})
+	got, err := dec.Decode()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if got != StringType("foo") {
+		t.Fatalf("expect foo got %v", got)
+	}
+	// the budget covers all values, including those switched to AMF3
+	_, err = dec.Decode()
+	if !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("expect error %v got %v", ErrLimitExceeded, err)
+	}
+}
@@ -0,0 +1,65 @@
+package amf0
+
+import (
+	"github.com/hongruiqi/amf.go/amf3"
+)
+
+// UsePlainTypes makes Decode return plain Go values instead of the wire
+// types: nil, bool, float64, string, time.Time, []interface{} and
+// map[string]interface{}. Values switched to AMF3 follow the rules of
+// amf3.Decoder.UsePlainTypes. Objects decoded more than once by reference
+// come back as the same map or slice.
+func (dec *Decoder) UsePlainTypes() {
+	dec.plain = true
+	if dec.plainRefs == nil {
+		dec.plainRefs = make(map[interface{}]interface{})
+	}
+}
+
+func (dec *Decoder) toPlain(v interface{}) interface{} {
+	switch value := v.(type) {
+	case NullType, UndefinedType, UnsupportedType:
+		return nil
+	case NumberType:
+		return float64(value)
+	case BooleanType:
+		return bool(value)
+	case StringType:
+		return string(value)
+	case LongStringType:
+		return string(value)
+	case XmlDocumentType:
+		return string(value)
+	case DateType:
+		return amf3.DateType(value.Date).Time()
+	case *ObjectType:
+		return dec.objectToPlain(value, _Object(*value))
+	case *EcmaArrayType:
+		return dec.objectToPlain(value, _Object(*value))
+	case *TypedObjectType:
+		return dec.objectToPlain(value, value.Object)
+	case *StrictArrayType:
+		if array, ok := dec.plainRefs[value]; ok {
+			return array
+		}
+		array := make([]interface{}, len(*value))
+		dec.plainRefs[value] = array
+		for i, item := range *value {
+			array[i] = dec.toPlain(item)
+		}
+		return array
+	}
+	return v
+}
+
+func (dec *Decoder) objectToPlain(ref interface{}, obj _Object) map[string]interface{} {
+	if m, ok := dec.plainRefs[ref]; ok {
+		return m.(map[string]interface{})
+	}
+	m := make(map[string]interface{}, len(obj))
+	dec.plainRefs[ref] = m
+	for k, v := range obj {
+		m[string(k)] = dec.toPlain(v)
+	}
+	return m
+}
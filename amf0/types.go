@@ -30,3 +30,20 @@ type TypedObjectType struct {
 	ClassName StringType
 	Object    _Object
 }
+
+// AvmPlusObjectType makes the Encoder switch to AMF3 for Value.
+type AvmPlusObjectType struct {
+	Value interface{}
+}
+
+// Marshaler is implemented by types that encode themselves through the
+// Encoder, usually as a single value written with EncodeValue.
+type Marshaler interface {
+	MarshalAMF0(enc *Encoder) error
+}
+
+// Unmarshaler is implemented by types that decode themselves from the
+// value produced by their MarshalAMF0.
+type Unmarshaler interface {
+	UnmarshalAMF0(dec *Decoder) error
+}
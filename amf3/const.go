@@ -14,4 +14,9 @@ const (
 	ObjectMarker
 	XmlMarker
 	ByteArrayMarker
+	VectorIntMarker
+	VectorUintMarker
+	VectorDoubleMarker
+	VectorObjectMarker
+	DictionaryMarker
 )
@@ -0,0 +1,18 @@
+package amf3
+
+import (
+	"math"
+	"time"
+)
+
+// NewDate returns the date of t, in milliseconds since the Unix epoch.
+func NewDate(t time.Time) DateType {
+	return DateType(float64(t.Unix())*1000 + float64(t.Nanosecond())/1e6)
+}
+
+// Time returns d as a time.Time in the local time zone.
+func (d DateType) Time() time.Time {
+	sec := math.Floor(float64(d) / 1000)
+	nsec := (float64(d) - sec*1000) * 1e6
+	return time.Unix(int64(sec), int64(nsec))
+}
@@ -0,0 +1,25 @@
+package amf3
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDate(t *testing.T) {
+	tests := []struct {
+		time time.Time
+		date DateType
+	}{
+		{time.Unix(0, 0), 0},
+		{time.Unix(1, 5000000), 1005},
+		{time.Unix(-1, 500000000), -500},
+	}
+	for _, test := range tests {
+		if got := NewDate(test.time); got != test.date {
+			t.Errorf("%v: expect %v got %v", test.time, test.date, got)
+		}
+		if got := test.date.Time(); !got.Equal(test.time) {
+			t.Errorf("%v: expect %v got %v", test.date, test.time, got)
+		}
+	}
+}
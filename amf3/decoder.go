@@ -2,24 +2,66 @@ package amf3
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/binary"
-	"errors"
+	"fmt"
+	"github.com/hongruiqi/amf.go/internal/stream"
 	"io"
 	"math"
 )
 
 type Decoder struct {
-	r          io.Reader
+	r          *stream.Reader
+	buf        *bufio.Reader // buffering added to r, if any
 	refStrings []StringType  // Strings
 	refObjects []interface{} // Object, Array, XML, XMLDocument, ByteArray, Date and instances of user defined Classes
 	refTraits  []*Trait      // Objects and instances of user defined Classes have trait information
+	limits     DecoderLimits
+	depth      int         // nesting of the value being decoded
+	path       stream.Path // member names and item indexes leading to it
+	unwrap     bool
+	plain      bool
+	plainRefs  map[interface{}]interface{} // wire objects already converted to plain values
 }
 
+// NewDecoder returns a decoder reading from r. Readers that can read a byte
+// at a time, such as a bufio.Reader, a bytes.Reader or the reader of an
+// amf0.Decoder, are used as is and never read past the values decoded, so
+// the input may go on with other data. Other readers are buffered, and what
+// was read ahead is left in Buffered.
 func NewDecoder(r io.Reader) *Decoder {
-	if _, ok := r.(*bufio.Reader); ok {
-		return &Decoder{r: r}
+	dec := new(Decoder)
+	if _, ok := r.(io.ByteReader); !ok {
+		dec.buf = bufio.NewReader(r)
+		r = dec.buf
 	}
-	return &Decoder{r: bufio.NewReader(r)}
+	dec.r = &stream.Reader{R: r}
+	return dec
+}
+
+// Buffered returns the input read ahead of the values decoded so far. It is
+// empty when the decoder reads from r directly.
+func (dec *Decoder) Buffered() io.Reader {
+	if dec.buf == nil {
+		return bytes.NewReader(nil)
+	}
+	b, _ := dec.buf.Peek(dec.buf.Buffered())
+	return bytes.NewReader(b)
+}
+
+// InputOffset returns the number of input bytes taken by the values decoded
+// so far.
+func (dec *Decoder) InputOffset() int64 {
+	return dec.r.Off
+}
+
+// DecodeBytes decodes the value at the start of b and returns it with the
+// number of bytes it took. It reads b in place, without buffering, and
+// ByteArrays in the result share memory with b.
+func DecodeBytes(b []byte) (v interface{}, n int, err error) {
+	dec := &Decoder{r: &stream.Reader{B: b}}
+	v, err = dec.Decode()
+	return v, int(dec.r.Off), err
 }
 
 func (dec *Decoder) Decode() (interface{}, error) {
@@ -27,16 +69,33 @@ func (dec *Decoder) Decode() (interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
+	if dec.plain {
+		return dec.toPlain(v)
+	}
 	return v, nil
 }
 
-func (dec *Decoder) decodeValue() (interface{}, error) {
-	u8 := make([]byte, 1)
-	_, err := dec.r.Read(u8)
+func (dec *Decoder) decodeValue() (_ interface{}, err error) {
+	offset := dec.r.Off
+	marker, err := dec.r.ReadByte()
 	if err != nil {
 		return nil, err
 	}
-	switch u8[0] {
+	dec.depth++
+	defer func() {
+		dec.depth--
+		// the stream may only end between values
+		if err == io.EOF {
+			err = ErrUnexpectedEOF
+		}
+		if _, ok := err.(*DecodeError); err != nil && !ok {
+			err = &DecodeError{Offset: offset, Marker: marker, Path: dec.path.String(), Err: err}
+		}
+	}()
+	if dec.limits.MaxDepth > 0 && dec.depth > dec.limits.MaxDepth {
+		return nil, fmt.Errorf("%w: depth %d", ErrLimitExceeded, dec.depth)
+	}
+	switch marker {
 	case UndefinedMarker:
 		return UndefinedType{}, nil
 	case NullMarker:
@@ -46,7 +105,7 @@ func (dec *Decoder) decodeValue() (interface{}, error) {
 	case TrueMarker:
 		return TrueType{}, nil
 	case IntegerMarker:
-		i, err := DecodeUInt29(dec.r)
+		i, err := DecodeInt29(dec.r)
 		if err != nil {
 			return nil, err
 		}
@@ -76,16 +135,18 @@ func (dec *Decoder) decodeValue() (interface{}, error) {
 			}
 			var ok bool
 			if xmldoc, ok = obj.(*XMLDocumentType); !ok {
-				return nil, errors.New("wrong ref type")
+				return nil, fmt.Errorf("%w: wrong ref type", ErrBadReference)
 			}
 		} else {
-			strBytes := make([]byte, i)
-			_, err = dec.r.Read(strBytes)
+			strBytes, err := dec.readBytes(i)
 			if err != nil {
 				return nil, err
 			}
 			*xmldoc = XMLDocumentType(strBytes)
-			dec.refObjects = append(dec.refObjects, xmldoc)
+			err = dec.refObject(xmldoc)
+			if err != nil {
+				return nil, err
+			}
 		}
 		return xmldoc, nil
 	case DateMarker:
@@ -101,7 +162,7 @@ func (dec *Decoder) decodeValue() (interface{}, error) {
 			}
 			var ok bool
 			if date, ok = obj.(*DateType); !ok {
-				return nil, errors.New("wrong ref type")
+				return nil, fmt.Errorf("%w: wrong ref type", ErrBadReference)
 			}
 		} else {
 			f, err := dec.readFloat()
@@ -109,24 +170,37 @@ func (dec *Decoder) decodeValue() (interface{}, error) {
 				return nil, err
 			}
 			*date = DateType(f)
-			dec.refObjects = append(dec.refObjects, date)
+			err = dec.refObject(date)
+			if err != nil {
+				return nil, err
+			}
 		}
 		return date, nil
 	case ArrayMarker:
 		ref, i, err := dec.readRefInt()
+		if err != nil {
+			return nil, err
+		}
 		if ref {
 			obj, err := dec.getRefObject(i)
 			if err != nil {
 				return nil, err
 			}
 			if _, ok := obj.(*ArrayType); !ok {
-				return nil, errors.New("wrong ref type")
+				return nil, fmt.Errorf("%w: wrong ref type", ErrBadReference)
 			}
 			return obj, nil
 		} else {
 			denseCount := i
-			array := new(ArrayType)
-			dec.refObjects = append(dec.refObjects, array)
+			err = dec.checkCollectionLength(denseCount)
+			if err != nil {
+				return nil, err
+			}
+			array := &ArrayType{Associative: make(map[StringType]interface{})}
+			err = dec.refObject(array)
+			if err != nil {
+				return nil, err
+			}
 			for {
 				s, err := dec.readString()
 				if err != nil {
@@ -135,17 +209,22 @@ func (dec *Decoder) decodeValue() (interface{}, error) {
 				if s == "" {
 					break
 				}
-				array.Associative[s], err = dec.decodeValue()
+				err = dec.checkCollectionLength(uint32(len(array.Associative)) + 1)
+				if err != nil {
+					return nil, err
+				}
+				array.Associative[s], err = dec.decodeMember(s)
 				if err != nil {
 					return nil, err
 				}
 			}
-			array.Dense = make([]interface{}, denseCount)
-			for k := 0; k < int(denseCount); k++ {
-				array.Dense[k], err = dec.decodeValue()
+			array.Dense = make([]interface{}, 0, stream.CapHint(denseCount))
+			for k := uint32(0); k < denseCount; k++ {
+				value, err := dec.decodeItem(int(k))
 				if err != nil {
 					return nil, err
 				}
+				array.Dense = append(array.Dense, value)
 			}
 			return array, nil
 		}
@@ -162,16 +241,18 @@ func (dec *Decoder) decodeValue() (interface{}, error) {
 			}
 			var ok bool
 			if xml, ok = obj.(*XMLType); !ok {
-				return nil, errors.New("wrong ref type")
+				return nil, fmt.Errorf("%w: wrong ref type", ErrBadReference)
 			}
 		} else {
-			strBytes := make([]byte, i)
-			_, err = dec.r.Read(strBytes)
+			strBytes, err := dec.readBytes(i)
 			if err != nil {
 				return nil, err
 			}
 			*xml = XMLType(strBytes)
-			dec.refObjects = append(dec.refObjects, xml)
+			err = dec.refObject(xml)
+			if err != nil {
+				return nil, err
+			}
 		}
 		return xml, nil
 	case ByteArrayMarker:
@@ -185,20 +266,68 @@ func (dec *Decoder) decodeValue() (interface{}, error) {
 				return nil, err
 			}
 			if _, ok := obj.(*ByteArrayType); !ok {
-				return nil, errors.New("wrong ref type")
+				return nil, fmt.Errorf("%w: wrong ref type", ErrBadReference)
 			}
 			return obj, nil
 		} else {
-			byteArray := make([]byte, i)
-			_, err = dec.r.Read(byteArray)
+			byteArray, err := dec.readBytes(i)
 			if err != nil {
 				return nil, err
 			}
 			pbyteArray := new(ByteArrayType)
 			*pbyteArray = ByteArrayType(byteArray)
-			dec.refObjects = append(dec.refObjects, pbyteArray)
+			err = dec.refObject(pbyteArray)
+			if err != nil {
+				return nil, err
+			}
 			return pbyteArray, nil
 		}
+	case VectorIntMarker, VectorUintMarker, VectorDoubleMarker, VectorObjectMarker:
+		return dec.readVector(marker)
+	case DictionaryMarker:
+		ref, i, err := dec.readRefInt()
+		if err != nil {
+			return nil, err
+		}
+		if ref {
+			obj, err := dec.getRefObject(i)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := obj.(*DictionaryType); !ok {
+				return nil, fmt.Errorf("%w: wrong ref type", ErrBadReference)
+			}
+			return obj, nil
+		} else {
+			err = dec.checkCollectionLength(i)
+			if err != nil {
+				return nil, err
+			}
+			dict := new(DictionaryType)
+			err = dec.refObject(dict)
+			if err != nil {
+				return nil, err
+			}
+			weakKeys, err := dec.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			dict.WeakKeys = weakKeys != 0
+			dict.Entries = make([]DictionaryEntry, 0, stream.CapHint(i))
+			for k := uint32(0); k < i; k++ {
+				var entry DictionaryEntry
+				entry.Key, err = dec.decodeItem(int(k))
+				if err != nil {
+					return nil, err
+				}
+				entry.Value, err = dec.decodeItem(int(k))
+				if err != nil {
+					return nil, err
+				}
+				dict.Entries = append(dict.Entries, entry)
+			}
+			return dict, nil
+		}
 	case ObjectMarker:
 		ref, i, err := dec.readRefInt()
 		if err != nil {
@@ -210,69 +339,213 @@ func (dec *Decoder) decodeValue() (interface{}, error) {
 			if err != nil {
 				return nil, err
 			}
-			if _, ok := obj.(*ObjectType); !ok {
-				return nil, errors.New("wrong ref type")
+			object, ok := obj.(*ObjectType)
+			if !ok {
+				return nil, fmt.Errorf("%w: wrong ref type", ErrBadReference)
 			}
-			return obj, nil
+			return dec.unwrapped(object), nil
 		} else {
 			obj := new(ObjectType)
-			dec.refObjects = append(dec.refObjects, obj)
+			err = dec.refObject(obj)
+			if err != nil {
+				return nil, err
+			}
 			var trait *Trait
 			if i&0x01 == 0 {
 				trait, err = dec.getRefTrait(i >> 1)
 				if err != nil {
 					return nil, err
 				}
+			} else if i&0x02 != 0 {
+				trait = new(Trait)
+				trait.IsExternalizable = true
+				trait.ClassName, err = dec.readString()
+				if err != nil {
+					return nil, err
+				}
+				err = dec.checkReferences(len(dec.refTraits))
+				if err != nil {
+					return nil, err
+				}
+				dec.refTraits = append(dec.refTraits, trait)
 			} else {
 				trait = new(Trait)
-				if i&0x02 == 0 {
-					trait.IsDynamic = i&0x04 == 1
-					attrsCount := int(i >> 3)
-					trait = new(Trait)
-					trait.ClassName, err = dec.readString()
+				trait.IsDynamic = i&0x04 != 0
+				attrsCount := i >> 3
+				err = dec.checkCollectionLength(attrsCount)
+				if err != nil {
+					return nil, err
+				}
+				trait.ClassName, err = dec.readString()
+				if err != nil {
+					return nil, err
+				}
+				trait.Attrs = make([]StringType, 0, stream.CapHint(attrsCount))
+				for k := uint32(0); k < attrsCount; k++ {
+					attr, err := dec.readString()
 					if err != nil {
 						return nil, err
 					}
-					trait.Attrs = make([]StringType, attrsCount)
-					for k := 0; k < attrsCount; k++ {
-						trait.Attrs[k], err = dec.readString()
-						if err != nil {
-							return nil, err
-						}
-					}
-					dec.refTraits = append(dec.refTraits, trait)
-				} else {
-					return nil, errors.New("traits-ext not support")
+					trait.Attrs = append(trait.Attrs, attr)
+				}
+				err = dec.checkReferences(len(dec.refTraits))
+				if err != nil {
+					return nil, err
 				}
-				obj.Trait = trait
-				obj.Static = make([]interface{}, len(trait.Attrs))
-				for k := 0; k < len(trait.Attrs); k++ {
-					obj.Static[k], err = dec.decodeValue()
+				dec.refTraits = append(dec.refTraits, trait)
+			}
+			obj.Trait = trait
+			if trait.IsExternalizable {
+				ext, err := lookupExternalizable(trait.ClassName)
+				if err != nil {
+					return nil, err
+				}
+				obj.External, err = ext.r(dec)
+				if err != nil {
+					return nil, err
+				}
+				return dec.unwrapped(obj), nil
+			}
+			obj.Static = make([]interface{}, len(trait.Attrs))
+			for k := 0; k < len(trait.Attrs); k++ {
+				obj.Static[k], err = dec.decodeMember(trait.Attrs[k])
+				if err != nil {
+					return nil, err
+				}
+			}
+			obj.Dynamic = make(map[StringType]interface{})
+			if trait.IsDynamic {
+				for {
+					name, err := dec.readString()
 					if err != nil {
 						return nil, err
 					}
-				}
-				obj.Dynamic = make(map[StringType]interface{})
-				if trait.IsDynamic {
-					for {
-						name, err := dec.readString()
-						if err != nil {
-							return nil, err
-						}
-						if name == "" {
-							break
-						}
-						obj.Dynamic[name], err = dec.decodeValue()
-						if err != nil {
-							return nil, err
-						}
+					if name == "" {
+						break
+					}
+					err = dec.checkCollectionLength(uint32(len(obj.Dynamic)) + 1)
+					if err != nil {
+						return nil, err
+					}
+					obj.Dynamic[name], err = dec.decodeMember(name)
+					if err != nil {
+						return nil, err
 					}
 				}
 			}
 			return obj, nil
 		}
 	}
-	return nil, nil
+	return nil, fmt.Errorf("%w 0x%02x", ErrUnknownMarker, marker)
+}
+
+func (dec *Decoder) readVector(marker byte) (interface{}, error) {
+	ref, i, err := dec.readRefInt()
+	if err != nil {
+		return nil, err
+	}
+	if ref {
+		obj, err := dec.getRefObject(i)
+		if err != nil {
+			return nil, err
+		}
+		var ok bool
+		switch marker {
+		case VectorIntMarker:
+			_, ok = obj.(*VectorIntType)
+		case VectorUintMarker:
+			_, ok = obj.(*VectorUintType)
+		case VectorDoubleMarker:
+			_, ok = obj.(*VectorDoubleType)
+		case VectorObjectMarker:
+			_, ok = obj.(*VectorObjectType)
+		}
+		if !ok {
+			return nil, fmt.Errorf("%w: wrong ref type", ErrBadReference)
+		}
+		return obj, nil
+	}
+	count := i
+	err = dec.checkCollectionLength(count)
+	if err != nil {
+		return nil, err
+	}
+	fixed, err := dec.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	switch marker {
+	case VectorIntMarker:
+		vector := &VectorIntType{Fixed: fixed != 0, Items: make([]int32, 0, stream.CapHint(count))}
+		err = dec.refObject(vector)
+		if err != nil {
+			return nil, err
+		}
+		for k := uint32(0); k < count; k++ {
+			u32, err := dec.r.Next(4)
+			if err != nil {
+				return nil, err
+			}
+			vector.Items = append(vector.Items, int32(binary.BigEndian.Uint32(u32)))
+		}
+		return vector, nil
+	case VectorUintMarker:
+		vector := &VectorUintType{Fixed: fixed != 0, Items: make([]uint32, 0, stream.CapHint(count))}
+		err = dec.refObject(vector)
+		if err != nil {
+			return nil, err
+		}
+		for k := uint32(0); k < count; k++ {
+			u32, err := dec.r.Next(4)
+			if err != nil {
+				return nil, err
+			}
+			vector.Items = append(vector.Items, binary.BigEndian.Uint32(u32))
+		}
+		return vector, nil
+	case VectorDoubleMarker:
+		vector := &VectorDoubleType{Fixed: fixed != 0, Items: make([]float64, 0, stream.CapHint(count))}
+		err = dec.refObject(vector)
+		if err != nil {
+			return nil, err
+		}
+		for k := uint32(0); k < count; k++ {
+			f, err := dec.readFloat()
+			if err != nil {
+				return nil, err
+			}
+			vector.Items = append(vector.Items, f)
+		}
+		return vector, nil
+	default:
+		vector := &VectorObjectType{Fixed: fixed != 0, Items: make([]interface{}, 0, stream.CapHint(count))}
+		err = dec.refObject(vector)
+		if err != nil {
+			return nil, err
+		}
+		vector.TypeName, err = dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		for k := uint32(0); k < count; k++ {
+			value, err := dec.decodeItem(int(k))
+			if err != nil {
+				return nil, err
+			}
+			vector.Items = append(vector.Items, value)
+		}
+		return vector, nil
+	}
+}
+
+// Read reads exactly len(p) raw bytes, for use by externalizable readers.
+func (dec *Decoder) Read(p []byte) (int, error) {
+	return io.ReadFull(dec.r, p)
+}
+
+// ReadByte reads a raw byte, for use by externalizable readers.
+func (dec *Decoder) ReadByte() (byte, error) {
+	return dec.r.ReadByte()
 }
 
 func (dec *Decoder) readRefInt() (ref bool, i uint32, err error) {
@@ -286,16 +559,12 @@ func (dec *Decoder) readRefInt() (ref bool, i uint32, err error) {
 }
 
 func (dec *Decoder) readFloat() (float64, error) {
-	u64 := make([]byte, 8)
-	_, err := dec.r.Read(u64)
+	u64, err := dec.r.Next(8)
 	if err != nil {
 		return 0, err
 	}
 	u64n := binary.BigEndian.Uint64(u64)
 	f := math.Float64frombits(u64n)
-	if err != nil {
-		return 0, err
-	}
 	return f, nil
 }
 
@@ -311,36 +580,47 @@ func (dec *Decoder) readString() (StringType, error) {
 			return "", err
 		}
 	} else {
-		strBytes := make([]byte, i)
-		_, err = dec.r.Read(strBytes)
+		strBytes, err := dec.readBytes(i)
 		if err != nil {
 			return "", err
 		}
 		str = StringType(strBytes)
 		if str != "" {
+			err = dec.checkReferences(len(dec.refStrings))
+			if err != nil {
+				return "", err
+			}
 			dec.refStrings = append(dec.refStrings, str)
 		}
 	}
 	return str, nil
 }
 
+func (dec *Decoder) readBytes(n uint32) ([]byte, error) {
+	err := dec.checkStringLength(n)
+	if err != nil {
+		return nil, err
+	}
+	return dec.r.ReadBytes(n)
+}
+
 func (dec *Decoder) getRefString(i uint32) (StringType, error) {
 	if int(i) >= len(dec.refStrings) {
-		return "", errors.New("refStrings index outbound")
+		return "", fmt.Errorf("%w: refStrings index %d outbound", ErrBadReference, i)
 	}
 	return dec.refStrings[i], nil
 }
 
 func (dec *Decoder) getRefObject(i uint32) (interface{}, error) {
 	if int(i) >= len(dec.refObjects) {
-		return nil, errors.New("refObjects index outbound")
+		return nil, fmt.Errorf("%w: refObjects index %d outbound", ErrBadReference, i)
 	}
 	return dec.refObjects[i], nil
 }
 
 func (dec *Decoder) getRefTrait(i uint32) (*Trait, error) {
 	if int(i) >= len(dec.refTraits) {
-		return nil, errors.New("refTraits index outbound")
+		return nil, fmt.Errorf("%w: refTraits index %d outbound", ErrBadReference, i)
 	}
 	return dec.refTraits[i], nil
 }
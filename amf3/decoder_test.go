@@ -0,0 +1,449 @@
+package amf3
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestDecodeDynamicObject(t *testing.T) {
+	buf := bytes.NewReader([]byte{0x0a, 0x0b, 0x01, 0x07, 0x66, 0x6f, 0x6f, 0x06, 0x07, 0x62, 0x61, 0x72, 0x01})
+	dec := NewDecoder(buf)
+	got, err := dec.Decode()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	obj, ok := got.(*ObjectType)
+	if !ok {
+		t.Fatalf("type incorrect")
+	}
+	if !obj.Trait.IsDynamic {
+		t.Fatalf("trait should be dynamic")
+	}
+	if obj.Dynamic["foo"] != StringType("bar") {
+		t.Fatalf("decode incorrect")
+	}
+}
+
+func TestDecodeTraitReference(t *testing.T) {
+	buf := bytes.NewReader([]byte{0x0a, 0x13, 0x03, 0x43, 0x03, 0x61, 0x04, 0x01, 0x0a, 0x01, 0x04, 0x02})
+	dec := NewDecoder(buf)
+	got1, err := dec.Decode()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	got2, err := dec.Decode()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	obj1, ok := got1.(*ObjectType)
+	if !ok {
+		t.Fatalf("type incorrect")
+	}
+	obj2, ok := got2.(*ObjectType)
+	if !ok {
+		t.Fatalf("type incorrect")
+	}
+	if obj1.Trait != obj2.Trait || obj1.Trait.ClassName != "C" {
+		t.Fatalf("trait incorrect")
+	}
+	if obj1.Static[0] != IntegerType(1) || obj2.Static[0] != IntegerType(2) {
+		t.Fatalf("decode incorrect")
+	}
+}
+
+func TestDecodeObjectReference(t *testing.T) {
+	buf := bytes.NewReader([]byte{0x0a, 0x0b, 0x01, 0x09, 0x73, 0x65, 0x6c, 0x66, 0x0a, 0x00, 0x01})
+	dec := NewDecoder(buf)
+	got, err := dec.Decode()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	obj, ok := got.(*ObjectType)
+	if !ok {
+		t.Fatalf("type incorrect")
+	}
+	if obj.Dynamic["self"] != obj {
+		t.Fatalf("decode incorrect")
+	}
+}
+
+func TestDecodeArrayReference(t *testing.T) {
+	buf := bytes.NewReader([]byte{0x09, 0x03, 0x01, 0x09, 0x00})
+	dec := NewDecoder(buf)
+	got, err := dec.Decode()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	array, ok := got.(*ArrayType)
+	if !ok {
+		t.Fatalf("type incorrect")
+	}
+	if len(array.Dense) != 1 || array.Dense[0] != array {
+		t.Fatalf("decode incorrect")
+	}
+}
+
+func TestDecodeVectorUint(t *testing.T) {
+	buf := bytes.NewReader([]byte{0x0e, 0x05, 0x00, 0x00, 0x00, 0x00, 0x01, 0xff, 0xff, 0xff, 0xff})
+	dec := NewDecoder(buf)
+	got, err := dec.Decode()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	vector, ok := got.(*VectorUintType)
+	if !ok {
+		t.Fatalf("type incorrect")
+	}
+	if vector.Fixed || len(vector.Items) != 2 || vector.Items[0] != 1 || vector.Items[1] != 0xffffffff {
+		t.Fatalf("decode incorrect %v", vector)
+	}
+}
+
+func TestDecodeVectorDouble(t *testing.T) {
+	buf := bytes.NewReader([]byte{0x0f, 0x03, 0x01, 0x40, 0x14, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
+	dec := NewDecoder(buf)
+	got, err := dec.Decode()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	vector, ok := got.(*VectorDoubleType)
+	if !ok {
+		t.Fatalf("type incorrect")
+	}
+	if !vector.Fixed || len(vector.Items) != 1 || vector.Items[0] != 5 {
+		t.Fatalf("decode incorrect %v", vector)
+	}
+}
+
+func TestDecodeVectorObjectReference(t *testing.T) {
+	buf := bytes.NewReader([]byte{0x10, 0x03, 0x00, 0x07, 0x66, 0x6f, 0x6f, 0x10, 0x00})
+	dec := NewDecoder(buf)
+	got, err := dec.Decode()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	vector, ok := got.(*VectorObjectType)
+	if !ok {
+		t.Fatalf("type incorrect")
+	}
+	if vector.TypeName != "foo" || len(vector.Items) != 1 || vector.Items[0] != vector {
+		t.Fatalf("decode incorrect %v", vector)
+	}
+}
+
+func TestDecodeDictionary(t *testing.T) {
+	buf := bytes.NewReader([]byte{0x11, 0x05, 0x00, 0x0a, 0x0b, 0x01, 0x01, 0x04, 0x01, 0x04, 0x02, 0x06, 0x07, 0x66, 0x6f, 0x6f})
+	dec := NewDecoder(buf)
+	got, err := dec.Decode()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	dict, ok := got.(*DictionaryType)
+	if !ok {
+		t.Fatalf("type incorrect")
+	}
+	if dict.WeakKeys || len(dict.Entries) != 2 {
+		t.Fatalf("decode incorrect %v", dict)
+	}
+	if _, ok := dict.Entries[0].Key.(*ObjectType); !ok {
+		t.Fatalf("key type incorrect")
+	}
+	if dict.Entries[0].Value != IntegerType(1) {
+		t.Fatalf("decode incorrect")
+	}
+	if dict.Entries[1].Key != IntegerType(2) || dict.Entries[1].Value != StringType("foo") {
+		t.Fatalf("decode incorrect")
+	}
+}
+
+func TestDecodeNegativeInteger(t *testing.T) {
+	buf := bytes.NewReader([]byte{0x04, 0xff, 0xff, 0xff, 0xff})
+	dec := NewDecoder(buf)
+	got, err := dec.Decode()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if got != IntegerType(-1) {
+		t.Fatalf("expect %v got %v", IntegerType(-1), got)
+	}
+}
+
+func TestDecodePlainTypes(t *testing.T) {
+	buf := bytes.NewReader([]byte{0x09, 0x09, 0x01, 0x04, 0x05, 0x06, 0x07, 0x66, 0x6f, 0x6f, 0x03, 0x09, 0x00})
+	dec := NewDecoder(buf)
+	dec.UsePlainTypes()
+	got, err := dec.Decode()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	array, ok := got.([]interface{})
+	if !ok || len(array) != 4 {
+		t.Fatalf("type incorrect %#v", got)
+	}
+	if array[0] != 5 || array[1] != "foo" || array[2] != true {
+		t.Fatalf("decode incorrect %#v", array)
+	}
+	self, ok := array[3].([]interface{})
+	if !ok || &self[0] != &array[0] {
+		t.Fatalf("reference not kept")
+	}
+}
+
+func TestDecodeAssociativeArray(t *testing.T) {
+	buf := bytes.NewReader([]byte{0x09, 0x03, 0x03, 0x61, 0x04, 0x01, 0x01, 0x01})
+	dec := NewDecoder(buf)
+	got, err := dec.Decode()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	array, ok := got.(*ArrayType)
+	if !ok {
+		t.Fatalf("type incorrect")
+	}
+	if array.Associative["a"] != IntegerType(1) || len(array.Dense) != 1 || array.Dense[0] != (NullType{}) {
+		t.Fatalf("decode incorrect %#v", array)
+	}
+}
+
+func TestDecodeMalformed(t *testing.T) {
+	tests := []struct {
+		data []byte
+		err  error
+	}{
+		{[]byte{}, io.EOF},
+		{[]byte{0x20}, ErrUnknownMarker},
+		{[]byte{0x04}, ErrUnexpectedEOF},
+		{[]byte{0x09, 0xff}, ErrUnexpectedEOF},
+		{[]byte{0x09, 0x03, 0x03, 0x61}, ErrUnexpectedEOF},
+		{[]byte{0x09, 0x03, 0x01}, ErrUnexpectedEOF},
+		{[]byte{0x06, 0x02}, ErrBadReference},
+		{[]byte{0x0a, 0x02}, ErrBadReference},
+		{[]byte{0x0a, 0x05}, ErrBadReference},
+		{[]byte{0x09, 0x03, 0x01, 0x0a, 0x00}, ErrBadReference},
+	}
+	for _, test := range tests {
+		got, err := NewDecoder(bytes.NewReader(test.data)).Decode()
+		if !errors.Is(err, test.err) {
+			t.Errorf("% x: expect error %v got %v", test.data, test.err, err)
+		}
+		if got != nil {
+			t.Errorf("% x: expect nil value got %#v", test.data, got)
+		}
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	f.Add([]byte{0x0a, 0x13, 0x03, 0x43, 0x03, 0x61, 0x04, 0x01, 0x0a, 0x01, 0x04, 0x02})
+	f.Add([]byte{0x09, 0x09, 0x01, 0x04, 0x05, 0x06, 0x07, 0x66, 0x6f, 0x6f, 0x03, 0x09, 0x00})
+	f.Add([]byte{0x11, 0x05, 0x00, 0x04, 0x01, 0x04, 0x02, 0x06, 0x07, 0x66, 0x6f, 0x6f})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		dec := NewDecoder(bytes.NewReader(data))
+		for {
+			v, err := dec.Decode()
+			if err != nil {
+				break
+			}
+			if v == nil {
+				t.Fatalf("nil value without error")
+			}
+		}
+		dec = NewDecoder(bytes.NewReader(data))
+		dec.UsePlainTypes()
+		for {
+			_, err := dec.Decode()
+			if err != nil {
+				break
+			}
+		}
+	})
+}
+
+var fragmentedValues = []interface{}{
+	"foo",
+	strings.Repeat("long", 5000),
+	-1,
+	1.5,
+	[]byte{0x01, 0x02, 0x03},
+	map[string]interface{}{"name": "foo", "items": []interface{}{1, "foo", true, nil}},
+	&VectorDoubleType{Items: []float64{1.5, 2.5}},
+	&DictionaryType{Entries: []DictionaryEntry{{Key: IntegerType(1), Value: StringType("one")}}},
+}
+
+// fragmenting readers split the input at every possible boundary, the way
+// a network connection may
+var fragmentingReaders = []struct {
+	name string
+	wrap func(io.Reader) io.Reader
+}{
+	{"OneByteReader", iotest.OneByteReader},
+	{"HalfReader", iotest.HalfReader},
+	{"DataErrReader", iotest.DataErrReader},
+}
+
+func TestDecodeFragmented(t *testing.T) {
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+	for _, v := range fragmentedValues {
+		err := enc.Encode(v)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+	data := buf.Bytes()
+	var expect []interface{}
+	dec := NewDecoder(bytes.NewReader(data))
+	dec.UsePlainTypes()
+	for range fragmentedValues {
+		v, err := dec.Decode()
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		expect = append(expect, v)
+	}
+	for _, fr := range fragmentingReaders {
+		dec := NewDecoder(fr.wrap(bytes.NewReader(data)))
+		dec.UsePlainTypes()
+		for i := range fragmentedValues {
+			got, err := dec.Decode()
+			if err != nil {
+				t.Fatalf("%s: value %d: %s", fr.name, i, err)
+			}
+			if !reflect.DeepEqual(got, expect[i]) {
+				t.Fatalf("%s: value %d: expect %#v got %#v", fr.name, i, expect[i], got)
+			}
+		}
+		_, err := dec.Decode()
+		if err != io.EOF {
+			t.Fatalf("%s: expect EOF got %v", fr.name, err)
+		}
+	}
+}
+
+func TestDecodeTruncated(t *testing.T) {
+	for _, v := range fragmentedValues {
+		buf := new(bytes.Buffer)
+		err := NewEncoder(buf).Encode(v)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		data := buf.Bytes()
+		for n := 1; n < len(data); n += 1 + n/16 {
+			_, err := NewDecoder(iotest.OneByteReader(bytes.NewReader(data[:n]))).Decode()
+			if !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Fatalf("% .16x truncated to %d bytes: expect %v got %v", data, n, io.ErrUnexpectedEOF, err)
+			}
+		}
+	}
+}
+
+func TestDecodeNoOverRead(t *testing.T) {
+	data := []byte{0x0a, 0x0b, 0x01, 0x03, 0x61, 0x06, 0x07, 0x66, 0x6f, 0x6f, 0x01, 0xde, 0xad, 0xbe, 0xef}
+	n := len(data) - 4
+	// readers able to read a byte at a time are read exactly
+	r := bufio.NewReader(bytes.NewReader(data))
+	dec := NewDecoder(r)
+	_, err := dec.Decode()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if dec.InputOffset() != int64(n) {
+		t.Fatalf("expect offset %d got %d", n, dec.InputOffset())
+	}
+	rest, _ := io.ReadAll(r)
+	if !bytes.Equal(rest, data[n:]) {
+		t.Fatalf("expect rest % x got % x", data[n:], rest)
+	}
+	// others leave what was read ahead in Buffered
+	src := struct{ io.Reader }{bytes.NewReader(data)}
+	dec = NewDecoder(src)
+	_, err = dec.Decode()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if dec.InputOffset() != int64(n) {
+		t.Fatalf("expect offset %d got %d", n, dec.InputOffset())
+	}
+	rest, _ = io.ReadAll(io.MultiReader(dec.Buffered(), src))
+	if !bytes.Equal(rest, data[n:]) {
+		t.Fatalf("expect rest % x got % x", data[n:], rest)
+	}
+}
+
+func TestDecodeBytes(t *testing.T) {
+	for i, v := range fragmentedValues {
+		buf := new(bytes.Buffer)
+		err := NewEncoder(buf).Encode(v)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		data := append(buf.Bytes(), 0xde, 0xad)
+		expect, err := NewDecoder(bytes.NewReader(data)).Decode()
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		got, n, err := DecodeBytes(data)
+		if err != nil {
+			t.Fatalf("value %d: %s", i, err)
+		}
+		if !reflect.DeepEqual(got, expect) {
+			t.Fatalf("value %d: expect %#v got %#v", i, expect, got)
+		}
+		if n != len(data)-2 {
+			t.Fatalf("value %d: expect %d bytes got %d", i, len(data)-2, n)
+		}
+		_, _, err = DecodeBytes(data[:n-1])
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Fatalf("value %d: expect %v got %v", i, io.ErrUnexpectedEOF, err)
+		}
+	}
+	_, n, err := DecodeBytes(nil)
+	if err != io.EOF || n != 0 {
+		t.Fatalf("expect EOF got %v after %d bytes", err, n)
+	}
+}
+
+// benchmarkValue is a typical RTMP command body.
+var benchmarkValue = []interface{}{"connect", 1, map[string]interface{}{
+	"app":         "live",
+	"tcUrl":       "rtmp://example.com/live",
+	"fpad":        false,
+	"audioCodecs": 3575,
+	"videoCodecs": 252,
+	"pageUrl":     nil,
+}}
+
+func BenchmarkDecode(b *testing.B) {
+	data, err := AppendEncode(nil, benchmarkValue)
+	if err != nil {
+		b.Fatalf("%s", err)
+	}
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, err := NewDecoder(bytes.NewReader(data)).Decode()
+		if err != nil {
+			b.Fatalf("%s", err)
+		}
+	}
+}
+
+func BenchmarkDecodeBytes(b *testing.B) {
+	data, err := AppendEncode(nil, benchmarkValue)
+	if err != nil {
+		b.Fatalf("%s", err)
+	}
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _, err := DecodeBytes(data)
+		if err != nil {
+			b.Fatalf("%s", err)
+		}
+	}
+}
@@ -1,24 +1,49 @@
 package amf3
 
 import (
-	"bufio"
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/hongruiqi/amf.go/internal/stream"
 	"io"
 	"math"
+	"reflect"
+	"time"
 )
 
 type Encoder struct {
 	w          io.Writer
-	bw         *bufio.Writer
-	refStrings []StringType  // Strings
-	refObjects []interface{} // Object, Array, XML, XMLDocument, ByteArray, Date and instances of user defined Classes
-	refTraits  []*Trait      // Objects and instances of user defined Classes have trait information	
+	bw         stream.Writer
+	refStrings map[StringType]int  // Strings
+	refObjects map[interface{}]int // Object, Array, XML, XMLDocument, ByteArray, Date and instances of user defined Classes
+	refTraits  map[*Trait]int      // Objects and instances of user defined Classes have trait information
+	traitKeys  map[string]int      // the same, indexed by content as given by appendTraitKey
+	nTraits    int
+	keyBuf     []byte
+	scratch    [8]byte
 }
 
+// NewEncoder returns an encoder writing to w. Writers that take single
+// bytes and strings, such as a bufio.Writer, a bytes.Buffer or the writer of
+// an amf0.Encoder, are written to directly and flushing them is left to the
+// caller. Other writers are buffered, and Encode flushes the buffer after
+// each value.
 func NewEncoder(w io.Writer) *Encoder {
-	return &Encoder{w: w, bw: bufio.NewWriter(w)}
+	return &Encoder{w: w, bw: stream.NewWriter(w)}
 }
 
+// AppendEncode appends the encoding of v to dst and returns the extended
+// buffer. It writes into the slice directly, without buffering.
+func AppendEncode(dst []byte, v interface{}) ([]byte, error) {
+	w := &stream.SliceWriter{B: dst}
+	err := (&Encoder{bw: w}).Encode(v)
+	if err != nil {
+		return dst, err
+	}
+	return w.B, nil
+}
+
+// Encode writes v and flushes the output.
 func (enc *Encoder) Encode(v interface{}) error {
 	err := enc.encodeValue(v)
 	if err != nil {
@@ -28,50 +53,60 @@ func (enc *Encoder) Encode(v interface{}) error {
 	return err
 }
 
+// EncodeValue writes v without flushing the output, for values that are
+// part of a larger one, such as those written by a Marshaler or an
+// ExternalWriter.
+func (enc *Encoder) EncodeValue(v interface{}) error {
+	return enc.encodeValue(v)
+}
+
 func (enc *Encoder) encodeValue(v interface{}) error {
-	u64 := make([]byte, 8)
-	if _, ok := v.(UndefinedType); ok {
-		_, err := enc.bw.Write([]byte{UndefinedMarker})
+	if value, ok := v.(Marshaler); ok {
+		return value.MarshalAMF3(enc)
+	} else if _, ok := v.(UndefinedType); ok {
+		err := enc.bw.WriteByte(UndefinedMarker)
 		if err != nil {
 			return err
 		}
 	} else if _, ok := v.(NullType); ok {
-		_, err := enc.bw.Write([]byte{NullMarker})
+		err := enc.bw.WriteByte(NullMarker)
 		if err != nil {
 			return err
 		}
 	} else if _, ok := v.(FalseType); ok {
-		_, err := enc.bw.Write([]byte{FalseMarker})
+		err := enc.bw.WriteByte(FalseMarker)
 		if err != nil {
 			return err
 		}
 	} else if _, ok := v.(TrueType); ok {
-		_, err := enc.bw.Write([]byte{TrueMarker})
+		err := enc.bw.WriteByte(TrueMarker)
 		if err != nil {
 			return err
 		}
 	} else if value, ok := v.(IntegerType); ok {
-		_, err := enc.bw.Write([]byte{IntegerMarker})
+		if value > MaxInteger || value < MinInteger {
+			// promote to double as Flash Player does
+			return enc.encodeValue(DoubleType(value))
+		}
+		err := enc.bw.WriteByte(IntegerMarker)
 		if err != nil {
 			return err
 		}
-		err = EncodeUInt29(enc.bw, uint32(value))
+		err = enc.writeInt29(int32(value))
 		if err != nil {
 			return err
 		}
 	} else if value, ok := v.(DoubleType); ok {
-		_, err := enc.bw.Write([]byte{DoubleMarker})
+		err := enc.bw.WriteByte(DoubleMarker)
 		if err != nil {
 			return err
 		}
-		number := math.Float64bits(float64(value))
-		binary.BigEndian.PutUint64(u64, number)
-		_, err = enc.bw.Write(u64)
+		err = enc.writeUint64(math.Float64bits(float64(value)))
 		if err != nil {
 			return err
 		}
 	} else if value, ok := v.(StringType); ok {
-		_, err := enc.bw.Write([]byte{StringMarker})
+		err := enc.bw.WriteByte(StringMarker)
 		if err != nil {
 			return err
 		}
@@ -80,7 +115,7 @@ func (enc *Encoder) encodeValue(v interface{}) error {
 			return err
 		}
 	} else if value, ok := v.(*XMLDocumentType); ok {
-		_, err := enc.bw.Write([]byte{XmlDocMarker})
+		err := enc.bw.WriteByte(XmlDocMarker)
 		if err != nil {
 			return err
 		}
@@ -91,14 +126,14 @@ func (enc *Encoder) encodeValue(v interface{}) error {
 		if ok {
 			return nil
 		} else {
-			enc.refObjects = append(enc.refObjects, value)
-			err = writeUTF8(enc.bw, string(*value))
+			enc.refObject(value)
+			err = enc.writeUTF8(string(*value))
 			if err != nil {
 				return err
 			}
 		}
 	} else if value, ok := v.(*XMLType); ok {
-		_, err := enc.bw.Write([]byte{XmlMarker})
+		err := enc.bw.WriteByte(XmlMarker)
 		if err != nil {
 			return err
 		}
@@ -109,14 +144,14 @@ func (enc *Encoder) encodeValue(v interface{}) error {
 		if ok {
 			return nil
 		} else {
-			enc.refObjects = append(enc.refObjects, value)
-			err = writeUTF8(enc.bw, string(*value))
+			enc.refObject(value)
+			err = enc.writeUTF8(string(*value))
 			if err != nil {
 				return err
 			}
 		}
 	} else if value, ok := v.(*DateType); ok {
-		_, err := enc.bw.Write([]byte{DateMarker})
+		err := enc.bw.WriteByte(DateMarker)
 		if err != nil {
 			return err
 		}
@@ -127,16 +162,18 @@ func (enc *Encoder) encodeValue(v interface{}) error {
 		if ok {
 			return nil
 		} else {
-			enc.refObjects = append(enc.refObjects, value)
-			f := math.Float64bits(float64(*value))
-			binary.BigEndian.PutUint64(u64, f)
-			_, err = enc.bw.Write(u64)
+			enc.refObject(value)
+			err = enc.writeUInt29(0x01)
+			if err != nil {
+				return err
+			}
+			err = enc.writeUint64(math.Float64bits(float64(*value)))
 			if err != nil {
 				return err
 			}
 		}
 	} else if value, ok := v.(*ByteArrayType); ok {
-		_, err := enc.bw.Write([]byte{ByteArrayMarker})
+		err := enc.bw.WriteByte(ByteArrayMarker)
 		if err != nil {
 			return err
 		}
@@ -147,58 +184,608 @@ func (enc *Encoder) encodeValue(v interface{}) error {
 		if ok {
 			return nil
 		} else {
-			enc.refObjects = append(enc.refObjects, value)
+			enc.refObject(value)
 			length := len(*value)
-			err = EncodeUInt29(enc.bw, uint32(length<<1))
+			err = enc.writeUInt29(uint32(length<<1 | 0x01))
+			if err != nil {
+				return err
+			}
+			_, err = enc.bw.Write(*value)
+			if err != nil {
+				return err
+			}
+		}
+	} else if value, ok := v.(*ArrayType); ok {
+		if value == nil {
+			return enc.encodeValue(nil)
+		}
+		err := enc.bw.WriteByte(ArrayMarker)
+		if err != nil {
+			return err
+		}
+		ok, err := enc.writeObjectRef(value)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		} else {
+			enc.refObject(value)
+			err = enc.writeArray(value)
+			if err != nil {
+				return err
+			}
+		}
+	} else if value, ok := v.(*VectorIntType); ok {
+		err := enc.bw.WriteByte(VectorIntMarker)
+		if err != nil {
+			return err
+		}
+		ok, err := enc.writeObjectRef(value)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		} else {
+			enc.refObject(value)
+			err = enc.writeVectorInt(value)
 			if err != nil {
 				return err
 			}
-			enc.bw.Write([]byte(*value))
 		}
+	} else if value, ok := v.(*VectorUintType); ok {
+		err := enc.bw.WriteByte(VectorUintMarker)
+		if err != nil {
+			return err
+		}
+		ok, err := enc.writeObjectRef(value)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		} else {
+			enc.refObject(value)
+			err = enc.writeVectorUint(value)
+			if err != nil {
+				return err
+			}
+		}
+	} else if value, ok := v.(*VectorDoubleType); ok {
+		err := enc.bw.WriteByte(VectorDoubleMarker)
+		if err != nil {
+			return err
+		}
+		ok, err := enc.writeObjectRef(value)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		} else {
+			enc.refObject(value)
+			err = enc.writeVectorDouble(value)
+			if err != nil {
+				return err
+			}
+		}
+	} else if value, ok := v.(*VectorObjectType); ok {
+		err := enc.bw.WriteByte(VectorObjectMarker)
+		if err != nil {
+			return err
+		}
+		ok, err := enc.writeObjectRef(value)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		} else {
+			enc.refObject(value)
+			err = enc.writeVectorObject(value)
+			if err != nil {
+				return err
+			}
+		}
+	} else if value, ok := v.(*DictionaryType); ok {
+		err := enc.bw.WriteByte(DictionaryMarker)
+		if err != nil {
+			return err
+		}
+		ok, err := enc.writeObjectRef(value)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		} else {
+			enc.refObject(value)
+			err = enc.writeDictionary(value)
+			if err != nil {
+				return err
+			}
+		}
+	} else if value, ok := v.(*ObjectType); ok {
+		if value == nil {
+			return enc.encodeValue(nil)
+		}
+		err := enc.bw.WriteByte(ObjectMarker)
+		if err != nil {
+			return err
+		}
+		ok, err := enc.writeObjectRef(value)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		} else {
+			enc.refObject(value)
+			err = enc.writeObject(value)
+			if err != nil {
+				return err
+			}
+		}
+	} else if v == nil {
+		err := enc.bw.WriteByte(NullMarker)
+		if err != nil {
+			return err
+		}
+	} else if value, ok := v.(bool); ok {
+		if value {
+			return enc.encodeValue(TrueType{})
+		}
+		return enc.encodeValue(FalseType{})
+	} else if value, ok := v.(string); ok {
+		return enc.encodeValue(StringType(value))
+	} else if value, ok := toNumber(v); ok {
+		return enc.encodeValue(value)
+	} else if value, ok := v.(time.Time); ok {
+		date := NewDate(value)
+		return enc.encodeValue(&date)
+	} else if value, ok := v.([]byte); ok {
+		if value == nil {
+			return enc.encodeValue(nil)
+		}
+		err := enc.bw.WriteByte(ByteArrayMarker)
+		if err != nil {
+			return err
+		}
+		key := stream.SliceRef(reflect.ValueOf(value), len(enc.refObjects))
+		ok, err := enc.writeObjectRef(key)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			enc.refObject(key)
+			err = enc.writeUInt29(uint32(len(value)<<1 | 0x01))
+			if err != nil {
+				return err
+			}
+			_, err = enc.bw.Write(value)
+			if err != nil {
+				return err
+			}
+		}
+	} else if value, ok := v.([]interface{}); ok {
+		if value == nil {
+			return enc.encodeValue(nil)
+		}
+		err := enc.bw.WriteByte(ArrayMarker)
+		if err != nil {
+			return err
+		}
+		key := stream.SliceRef(reflect.ValueOf(value), len(enc.refObjects))
+		ok, err := enc.writeObjectRef(key)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			enc.refObject(key)
+			err = enc.writeArray(&ArrayType{Dense: value})
+			if err != nil {
+				return err
+			}
+		}
+	} else if value, ok := v.(map[string]interface{}); ok {
+		if value == nil {
+			return enc.encodeValue(nil)
+		}
+		err := enc.bw.WriteByte(ObjectMarker)
+		if err != nil {
+			return err
+		}
+		key := stream.MapRef(reflect.ValueOf(value))
+		ok, err := enc.writeObjectRef(key)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			enc.refObject(key)
+			obj := &ObjectType{Dynamic: make(map[StringType]interface{}, len(value))}
+			for k, v := range value {
+				obj.Dynamic[StringType(k)] = v
+			}
+			err = enc.writeObject(obj)
+			if err != nil {
+				return err
+			}
+		}
+	} else {
+		return fmt.Errorf("amf3: unsupported type %T", v)
 	}
 	return nil
 }
 
-func (enc *Encoder) writeString(str StringType) error {
-	for i, s := range enc.refStrings {
-		if s == str {
-			u := uint32(i<<1 | 0x01)
-			err := EncodeUInt29(enc.bw, u)
+// toNumber converts a Go number into IntegerType when it fits in 29 bits and
+// into DoubleType otherwise.
+func toNumber(v interface{}) (interface{}, bool) {
+	var i int64
+	switch n := v.(type) {
+	case float64:
+		return DoubleType(n), true
+	case float32:
+		return DoubleType(n), true
+	case int:
+		i = int64(n)
+	case int8:
+		i = int64(n)
+	case int16:
+		i = int64(n)
+	case int32:
+		i = int64(n)
+	case int64:
+		i = n
+	case uint:
+		if uint64(n) > MaxInteger {
+			return DoubleType(n), true
+		}
+		i = int64(n)
+	case uint8:
+		i = int64(n)
+	case uint16:
+		i = int64(n)
+	case uint32:
+		i = int64(n)
+	case uint64:
+		if n > MaxInteger {
+			return DoubleType(n), true
+		}
+		i = int64(n)
+	default:
+		return nil, false
+	}
+	if i > MaxInteger || i < MinInteger {
+		return DoubleType(i), true
+	}
+	return IntegerType(i), true
+}
+
+func (enc *Encoder) writeArray(array *ArrayType) error {
+	denseCount := len(array.Dense)
+	err := enc.writeUInt29(uint32(denseCount<<1 | 0x01))
+	if err != nil {
+		return err
+	}
+	for name, value := range array.Associative {
+		if name == "" {
+			return errors.New("empty associative key")
+		}
+		err = enc.writeString(name)
+		if err != nil {
+			return err
+		}
+		err = enc.encodeValue(value)
+		if err != nil {
 			return err
 		}
 	}
-	err := writeUTF8(enc.bw, string(str))
+	err = enc.writeString("")
 	if err != nil {
 		return err
 	}
-	enc.refStrings = append(enc.refStrings, str)
+	for _, value := range array.Dense {
+		err = enc.encodeValue(value)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func (enc *Encoder) writeObjectRef(v interface{}) (ok bool, err error) {
-	for i, obj := range enc.refObjects {
-		if obj == v {
-			u := uint32(i<<1 | 0x01)
-			err = EncodeUInt29(enc.bw, u)
+func (enc *Encoder) writeVectorHeader(count int, fixed bool) error {
+	err := enc.writeUInt29(uint32(count<<1 | 0x01))
+	if err != nil {
+		return err
+	}
+	if fixed {
+		return enc.bw.WriteByte(1)
+	}
+	return enc.bw.WriteByte(0)
+}
+
+func (enc *Encoder) writeVectorInt(vector *VectorIntType) error {
+	err := enc.writeVectorHeader(len(vector.Items), vector.Fixed)
+	if err != nil {
+		return err
+	}
+	for _, item := range vector.Items {
+		err = enc.writeUint32(uint32(item))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (enc *Encoder) writeVectorUint(vector *VectorUintType) error {
+	err := enc.writeVectorHeader(len(vector.Items), vector.Fixed)
+	if err != nil {
+		return err
+	}
+	for _, item := range vector.Items {
+		err = enc.writeUint32(item)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (enc *Encoder) writeVectorDouble(vector *VectorDoubleType) error {
+	err := enc.writeVectorHeader(len(vector.Items), vector.Fixed)
+	if err != nil {
+		return err
+	}
+	for _, item := range vector.Items {
+		err = enc.writeUint64(math.Float64bits(item))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (enc *Encoder) writeVectorObject(vector *VectorObjectType) error {
+	err := enc.writeVectorHeader(len(vector.Items), vector.Fixed)
+	if err != nil {
+		return err
+	}
+	err = enc.writeString(vector.TypeName)
+	if err != nil {
+		return err
+	}
+	for _, item := range vector.Items {
+		err = enc.encodeValue(item)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (enc *Encoder) writeDictionary(dict *DictionaryType) error {
+	err := enc.writeUInt29(uint32(len(dict.Entries)<<1 | 0x01))
+	if err != nil {
+		return err
+	}
+	if dict.WeakKeys {
+		err = enc.bw.WriteByte(1)
+	} else {
+		err = enc.bw.WriteByte(0)
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range dict.Entries {
+		err = enc.encodeValue(entry.Key)
+		if err != nil {
+			return err
+		}
+		err = enc.encodeValue(entry.Value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (enc *Encoder) writeObject(obj *ObjectType) error {
+	trait := obj.Trait
+	if trait == nil {
+		trait = &Trait{IsDynamic: true}
+	}
+	if trait.IsExternalizable {
+		ext, err := lookupExternalizable(trait.ClassName)
+		if err != nil {
+			return err
+		}
+		err = enc.writeTrait(trait)
+		if err != nil {
+			return err
+		}
+		return ext.w(enc, obj.External)
+	}
+	if len(obj.Static) != len(trait.Attrs) {
+		return errors.New("static members count mismatch")
+	}
+	err := enc.writeTrait(trait)
+	if err != nil {
+		return err
+	}
+	for _, value := range obj.Static {
+		err = enc.encodeValue(value)
+		if err != nil {
+			return err
+		}
+	}
+	if trait.IsDynamic {
+		for name, value := range obj.Dynamic {
+			if name == "" {
+				return errors.New("empty dynamic member name")
+			}
+			err = enc.writeString(name)
+			if err != nil {
+				return err
+			}
+			err = enc.encodeValue(value)
 			if err != nil {
-				return false, err
+				return err
 			}
-			return true, nil
+		}
+		err = enc.writeString("")
+		if err != nil {
+			return err
 		}
 	}
-	return false, nil
+	return nil
 }
 
-func writeUTF8(w io.Writer, str string) error {
-	length := len(str)
-	u := uint32(length << 1)
-	err := EncodeUInt29(w, u)
+func (enc *Encoder) writeTrait(trait *Trait) error {
+	i, ok := enc.refTraits[trait]
+	if !ok {
+		// traits built afresh for each object still match by content
+		enc.keyBuf = appendTraitKey(enc.keyBuf[:0], trait)
+		i, ok = enc.traitKeys[string(enc.keyBuf)]
+	}
+	if ok {
+		return enc.writeUInt29(uint32(i<<2 | 0x01))
+	}
+	if enc.refTraits == nil {
+		enc.refTraits = make(map[*Trait]int)
+		enc.traitKeys = make(map[string]int)
+	}
+	enc.refTraits[trait] = enc.nTraits
+	enc.traitKeys[string(enc.keyBuf)] = enc.nTraits
+	enc.nTraits++
+	var u uint32
+	if trait.IsExternalizable {
+		u = 0x07
+	} else {
+		u = uint32(len(trait.Attrs)<<4 | 0x03)
+		if trait.IsDynamic {
+			u |= 0x08
+		}
+	}
+	err := enc.writeUInt29(u)
 	if err != nil {
 		return err
 	}
-	_, err = w.Write([]byte(str))
+	err = enc.writeString(trait.ClassName)
 	if err != nil {
 		return err
 	}
+	for _, attr := range trait.Attrs {
+		err = enc.writeString(attr)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
+// appendTraitKey appends to b a key that two traits share exactly when they
+// are equal.
+func appendTraitKey(b []byte, t *Trait) []byte {
+	var flags byte
+	if t.IsDynamic {
+		flags |= 1
+	}
+	if t.IsExternalizable {
+		flags |= 2
+	}
+	b = append(b, flags)
+	b = binary.AppendUvarint(b, uint64(len(t.ClassName)))
+	b = append(b, t.ClassName...)
+	for _, attr := range t.Attrs {
+		b = binary.AppendUvarint(b, uint64(len(attr)))
+		b = append(b, attr...)
+	}
+	return b
+}
+
+// Write writes raw bytes to the stream, for use by externalizable writers.
+func (enc *Encoder) Write(p []byte) (int, error) {
+	return enc.bw.Write(p)
+}
+
+// WriteByte writes a raw byte to the stream, for use by externalizable writers.
+func (enc *Encoder) WriteByte(c byte) error {
+	return enc.bw.WriteByte(c)
+}
+
+func (enc *Encoder) writeString(str StringType) error {
+	if i, ok := enc.refStrings[str]; ok {
+		return enc.writeUInt29(uint32(i << 1))
+	}
+	err := enc.writeUTF8(string(str))
+	if err != nil {
+		return err
+	}
+	if str != "" {
+		if enc.refStrings == nil {
+			enc.refStrings = make(map[StringType]int)
+		}
+		enc.refStrings[str] = len(enc.refStrings)
+	}
+	return nil
+}
+
+func (enc *Encoder) writeObjectRef(v interface{}) (ok bool, err error) {
+	i, ok := enc.refObjects[v]
+	if !ok {
+		return false, nil
+	}
+	err = enc.writeUInt29(uint32(i << 1))
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// refObject adds v to the object reference table.
+func (enc *Encoder) refObject(v interface{}) {
+	if enc.refObjects == nil {
+		enc.refObjects = make(map[interface{}]int)
+	}
+	enc.refObjects[v] = len(enc.refObjects)
+}
+
+func (enc *Encoder) writeUTF8(str string) error {
+	err := enc.writeUInt29(uint32(len(str)<<1 | 0x01))
+	if err != nil {
+		return err
+	}
+	_, err = enc.bw.WriteString(str)
+	return err
+}
+
+func (enc *Encoder) writeUInt29(n uint32) error {
+	b, err := appendUInt29(enc.scratch[:0], n)
+	if err != nil {
+		return err
+	}
+	_, err = enc.bw.Write(b)
+	return err
+}
+
+func (enc *Encoder) writeInt29(n int32) error {
+	un, err := S2UInt29(n)
+	if err != nil {
+		return err
+	}
+	return enc.writeUInt29(un)
+}
+
+func (enc *Encoder) writeUint32(n uint32) error {
+	binary.BigEndian.PutUint32(enc.scratch[:4], n)
+	_, err := enc.bw.Write(enc.scratch[:4])
+	return err
+}
+
+func (enc *Encoder) writeUint64(n uint64) error {
+	binary.BigEndian.PutUint64(enc.scratch[:], n)
+	_, err := enc.bw.Write(enc.scratch[:])
+	return err
+}
@@ -0,0 +1,410 @@
+package amf3
+
+import (
+	"bytes"
+	"reflect"
+	"runtime"
+	"strconv"
+	"testing"
+)
+
+func TestEncodeDynamicObject(t *testing.T) {
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+	obj := new(ObjectType)
+	obj.Dynamic = make(map[StringType]interface{})
+	obj.Dynamic["foo"] = StringType("bar")
+	err := enc.Encode(obj)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	expect := []byte{0x0a, 0x0b, 0x01, 0x07, 0x66, 0x6f, 0x6f, 0x06, 0x07, 0x62, 0x61, 0x72, 0x01}
+	got := buf.Bytes()
+	if !bytes.Equal(expect, got) {
+		t.Errorf("expect %x got %x", expect, got)
+	}
+}
+
+func TestEncodeObjectReference(t *testing.T) {
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+	obj := new(ObjectType)
+	obj.Dynamic = make(map[StringType]interface{})
+	obj.Dynamic["self"] = obj
+	err := enc.Encode(obj)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	expect := []byte{0x0a, 0x0b, 0x01, 0x09, 0x73, 0x65, 0x6c, 0x66, 0x0a, 0x00, 0x01}
+	got := buf.Bytes()
+	if !bytes.Equal(expect, got) {
+		t.Errorf("expect %x got %x", expect, got)
+	}
+}
+
+func TestEncodeNilObject(t *testing.T) {
+	got, err := AppendEncode(nil, (*ObjectType)(nil))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	expect := []byte{NullMarker}
+	if !bytes.Equal(expect, got) {
+		t.Errorf("expect %x got %x", expect, got)
+	}
+}
+
+func TestEncodeTraitReference(t *testing.T) {
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+	trait := &Trait{ClassName: "C", Attrs: []StringType{"a"}}
+	obj1 := &ObjectType{Trait: trait, Static: []interface{}{IntegerType(1)}}
+	obj2 := &ObjectType{Trait: trait, Static: []interface{}{IntegerType(2)}}
+	err := enc.Encode(obj1)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	err = enc.Encode(obj2)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	expect := []byte{0x0a, 0x13, 0x03, 0x43, 0x03, 0x61, 0x04, 0x01, 0x0a, 0x01, 0x04, 0x02}
+	got := buf.Bytes()
+	if !bytes.Equal(expect, got) {
+		t.Errorf("expect %x got %x", expect, got)
+	}
+}
+
+func TestEncodeStringReference(t *testing.T) {
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+	err := enc.Encode(StringType("foo"))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	err = enc.Encode(StringType("foo"))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	expect := []byte{0x06, 0x07, 0x66, 0x6f, 0x6f, 0x06, 0x00}
+	got := buf.Bytes()
+	if !bytes.Equal(expect, got) {
+		t.Errorf("expect %x got %x", expect, got)
+	}
+}
+
+func TestEncodeArray(t *testing.T) {
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+	array := new(ArrayType)
+	array.Associative = make(map[StringType]interface{})
+	array.Associative["foo"] = StringType("bar")
+	array.Dense = []interface{}{IntegerType(1), StringType("bar")}
+	err := enc.Encode(array)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	expect := []byte{0x09, 0x05, 0x07, 0x66, 0x6f, 0x6f, 0x06, 0x07, 0x62, 0x61, 0x72, 0x01, 0x04, 0x01, 0x06, 0x02}
+	got := buf.Bytes()
+	if !bytes.Equal(expect, got) {
+		t.Errorf("expect %x got %x", expect, got)
+	}
+}
+
+func TestEncodeNilArray(t *testing.T) {
+	got, err := AppendEncode(nil, (*ArrayType)(nil))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	expect := []byte{NullMarker}
+	if !bytes.Equal(expect, got) {
+		t.Errorf("expect %x got %x", expect, got)
+	}
+}
+
+func TestEncodeArrayReference(t *testing.T) {
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+	array := new(ArrayType)
+	array.Dense = []interface{}{nil}
+	array.Dense[0] = array
+	err := enc.Encode(array)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	expect := []byte{0x09, 0x03, 0x01, 0x09, 0x00}
+	got := buf.Bytes()
+	if !bytes.Equal(expect, got) {
+		t.Errorf("expect %x got %x", expect, got)
+	}
+}
+
+func TestEncodeVectorInt(t *testing.T) {
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+	vector := &VectorIntType{Fixed: true, Items: []int32{1, -1}}
+	err := enc.Encode(vector)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	expect := []byte{0x0d, 0x05, 0x01, 0x00, 0x00, 0x00, 0x01, 0xff, 0xff, 0xff, 0xff}
+	got := buf.Bytes()
+	if !bytes.Equal(expect, got) {
+		t.Errorf("expect %x got %x", expect, got)
+	}
+}
+
+func TestEncodeVectorObject(t *testing.T) {
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+	vector := &VectorObjectType{TypeName: "foo", Items: []interface{}{StringType("foo")}}
+	err := enc.Encode(vector)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	expect := []byte{0x10, 0x03, 0x00, 0x07, 0x66, 0x6f, 0x6f, 0x06, 0x00}
+	got := buf.Bytes()
+	if !bytes.Equal(expect, got) {
+		t.Errorf("expect %x got %x", expect, got)
+	}
+}
+
+func TestEncodeDictionary(t *testing.T) {
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+	dict := &DictionaryType{WeakKeys: true}
+	dict.Entries = []DictionaryEntry{{Key: dict, Value: TrueType{}}}
+	err := enc.Encode(dict)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	expect := []byte{0x11, 0x03, 0x01, 0x11, 0x00, 0x03}
+	got := buf.Bytes()
+	if !bytes.Equal(expect, got) {
+		t.Errorf("expect %x got %x", expect, got)
+	}
+}
+
+func TestEncodeInteger(t *testing.T) {
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+	err := enc.Encode(IntegerType(-1))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	err = enc.Encode(IntegerType(MaxInteger + 1))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	expect := []byte{0x04, 0xff, 0xff, 0xff, 0xff, 0x05, 0x41, 0xb0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+	got := buf.Bytes()
+	if !bytes.Equal(expect, got) {
+		t.Errorf("expect %x got %x", expect, got)
+	}
+}
+
+func TestEncodeNative(t *testing.T) {
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+	err := enc.Encode([]interface{}{"foo", 5, int64(1) << 40, false, nil, []byte{0x01}})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	expect := []byte{0x09, 0x0d, 0x01, 0x06, 0x07, 0x66, 0x6f, 0x6f, 0x04, 0x05, 0x05, 0x42, 0x70, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x02, 0x01, 0x0c, 0x03, 0x01}
+	got := buf.Bytes()
+	if !bytes.Equal(expect, got) {
+		t.Errorf("expect %x got %x", expect, got)
+	}
+}
+
+func TestEncodeNativeMap(t *testing.T) {
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+	m := map[string]interface{}{}
+	m["self"] = m
+	err := enc.Encode(m)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	expect := []byte{0x0a, 0x0b, 0x01, 0x09, 0x73, 0x65, 0x6c, 0x66, 0x0a, 0x00, 0x01}
+	got := buf.Bytes()
+	if !bytes.Equal(expect, got) {
+		t.Errorf("expect %x got %x", expect, got)
+	}
+}
+
+func TestEncodeNativeReferencesAcrossGC(t *testing.T) {
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+	for i := 0; i < 100; i++ {
+		buf.Reset()
+		err := enc.Encode([]interface{}{IntegerType(i)})
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		// a slice written before must not be mistaken for a new one that
+		// took its address
+		expect := []byte{0x09, 0x03, 0x01, 0x04, byte(i)}
+		if !bytes.Equal(expect, buf.Bytes()) {
+			t.Fatalf("expect %x got %x", expect, buf.Bytes())
+		}
+		runtime.GC()
+	}
+}
+
+func TestEncodeEmptySlices(t *testing.T) {
+	s := []interface{}{true}
+	data, err := AppendEncode(nil, []interface{}{[]interface{}{}, []interface{}{}, []byte{}, []byte{}, s, s})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	expect := []byte{0x09, 0x0d, 0x01,
+		0x09, 0x01, 0x01, 0x09, 0x01, 0x01, // each empty slice is written in full
+		0x0c, 0x01, 0x0c, 0x01,
+		0x09, 0x03, 0x01, 0x03,
+		0x09, 0x0a} // and still counts in the reference table
+	if !bytes.Equal(expect, data) {
+		t.Errorf("expect %x got %x", expect, data)
+	}
+}
+
+func TestEncodeUnsupported(t *testing.T) {
+	enc := NewEncoder(new(bytes.Buffer))
+	err := enc.Encode(struct{}{})
+	if err == nil || err.Error() != "amf3: unsupported type struct {}" {
+		t.Errorf("should report unsupported type, got %v", err)
+	}
+}
+
+type testPoint struct {
+	x, y int32
+}
+
+func (p testPoint) MarshalAMF3(enc *Encoder) error {
+	return enc.EncodeValue(&VectorIntType{Fixed: true, Items: []int32{p.x, p.y}})
+}
+
+func TestEncodeMarshaler(t *testing.T) {
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+	err := enc.Encode(testPoint{1, 2})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	expect := []byte{0x0d, 0x05, 0x01, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x02}
+	got := buf.Bytes()
+	if !bytes.Equal(expect, got) {
+		t.Errorf("expect %x got %x", expect, got)
+	}
+}
+
+// writeCounter counts the writes reaching it.
+type writeCounter int
+
+func (w *writeCounter) Write(p []byte) (int, error) {
+	*w++
+	return len(p), nil
+}
+
+func TestEncodeMarshalerFlush(t *testing.T) {
+	w := new(writeCounter)
+	err := NewEncoder(w).Encode([]interface{}{testPoint{1, 2}, testPoint{3, 4}})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if *w != 1 {
+		t.Errorf("expect the value in 1 write got %d", *w)
+	}
+}
+
+func TestAppendEncode(t *testing.T) {
+	for _, v := range fragmentedValues {
+		buf := new(bytes.Buffer)
+		err := NewEncoder(buf).Encode(v)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		prefix := []byte{0xca, 0xfe}
+		got, err := AppendEncode(prefix, v)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		if !bytes.Equal(got[:2], prefix) || len(got) != len(prefix)+buf.Len() {
+			t.Fatalf("expect % .32x after prefix got % .32x", buf.Bytes(), got)
+		}
+		// maps may come out in any order, so compare what decodes back
+		expect, err := NewDecoder(buf).Decode()
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		value, _, err := DecodeBytes(got[2:])
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		if !reflect.DeepEqual(value, expect) {
+			t.Fatalf("expect %#v got %#v", expect, value)
+		}
+	}
+}
+
+func BenchmarkEncode(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf := new(bytes.Buffer)
+		err := NewEncoder(buf).Encode(benchmarkValue)
+		if err != nil {
+			b.Fatalf("%s", err)
+		}
+	}
+}
+
+func BenchmarkAppendEncode(b *testing.B) {
+	b.ReportAllocs()
+	var buf []byte
+	for i := 0; i < b.N; i++ {
+		var err error
+		buf, err = AppendEncode(buf[:0], benchmarkValue)
+		if err != nil {
+			b.Fatalf("%s", err)
+		}
+	}
+}
+
+// benchmarkEncodeLarge encodes arrays of n items for several n; the time per
+// item stays flat as the reference tables grow.
+func benchmarkEncodeLarge(b *testing.B, item func(i int) interface{}) {
+	for _, n := range []int{1000, 10000, 100000} {
+		array := make([]interface{}, n)
+		for i := range array {
+			array[i] = item(i)
+		}
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			b.ReportAllocs()
+			var buf []byte
+			for i := 0; i < b.N; i++ {
+				var err error
+				buf, err = AppendEncode(buf[:0], array)
+				if err != nil {
+					b.Fatalf("%s", err)
+				}
+			}
+			b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*n), "ns/item")
+		})
+	}
+}
+
+func BenchmarkEncodeStrings(b *testing.B) {
+	benchmarkEncodeLarge(b, func(i int) interface{} {
+		return StringType("item" + strconv.Itoa(i))
+	})
+}
+
+// Each object has its own, equal trait, as Marshal builds them.
+func BenchmarkEncodeObjects(b *testing.B) {
+	benchmarkEncodeLarge(b, func(i int) interface{} {
+		return &ObjectType{
+			Trait:  &Trait{ClassName: "Item", Attrs: []StringType{"id", "name"}},
+			Static: []interface{}{IntegerType(i), StringType("item" + strconv.Itoa(i))},
+		}
+	})
+}
@@ -0,0 +1,53 @@
+package amf3
+
+import (
+	"errors"
+	"fmt"
+	"github.com/hongruiqi/amf.go/internal/stream"
+	"io"
+)
+
+// Errors returned by Decoder for malformed input. They are usually wrapped
+// with more detail, so test for them with errors.Is.
+var (
+	ErrUnknownMarker = errors.New("unknown marker")
+	ErrBadReference  = errors.New("bad reference")
+	ErrUnexpectedEOF = io.ErrUnexpectedEOF
+	ErrLimitExceeded = stream.ErrLimitExceeded
+)
+
+// DecodeError reports where in the input a Decoder failed.
+type DecodeError struct {
+	Offset int64  // offset of the value that failed, from where the decoder started
+	Marker byte   // marker of the value that failed
+	Path   string // member names and item indexes leading to the value, as in users[2].roles
+	Err    error  // the cause
+}
+
+func (e *DecodeError) Error() string {
+	s := fmt.Sprintf("amf3: offset %d, marker 0x%02x", e.Offset, e.Marker)
+	if e.Path != "" {
+		s += ", path " + e.Path
+	}
+	return s + ": " + e.Err.Error()
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// decodeMember decodes the value of the member name, keeping track of the path.
+func (dec *Decoder) decodeMember(name StringType) (interface{}, error) {
+	dec.path.PushMember(string(name))
+	v, err := dec.decodeValue()
+	dec.path.Pop()
+	return v, err
+}
+
+// decodeItem decodes the item at index i, keeping track of the path.
+func (dec *Decoder) decodeItem(i int) (interface{}, error) {
+	dec.path.PushItem(i)
+	v, err := dec.decodeValue()
+	dec.path.Pop()
+	return v, err
+}
@@ -0,0 +1,22 @@
+package amf3
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestDecodeErrorPath(t *testing.T) {
+	buf := bytes.NewReader([]byte{0x0a, 0x0b, 0x01, 0x03, 0x61, 0x09, 0x05, 0x01, 0x04, 0x01, 0x0a, 0x04})
+	_, err := NewDecoder(buf).Decode()
+	var e *DecodeError
+	if !errors.As(err, &e) {
+		t.Fatalf("expect DecodeError got %v", err)
+	}
+	if e.Offset != 10 || e.Marker != ObjectMarker || e.Path != "a[1]" {
+		t.Fatalf("decode error incorrect %+v", e)
+	}
+	if !errors.Is(err, ErrBadReference) {
+		t.Fatalf("expect %v got %v", ErrBadReference, e.Err)
+	}
+}
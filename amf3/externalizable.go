@@ -0,0 +1,61 @@
+package amf3
+
+import (
+	"errors"
+	"sync"
+)
+
+// ExternalReader reads the custom body of an externalizable object from dec.
+// Values it reads with Decode follow UsePlainTypes; DecodeWire reads the
+// wire types either way.
+type ExternalReader func(dec *Decoder) (interface{}, error)
+
+// ExternalWriter writes v as the custom body of an externalizable object,
+// with EncodeValue rather than Encode, which would flush mid-value.
+type ExternalWriter func(enc *Encoder, v interface{}) error
+
+type externalizer struct {
+	r ExternalReader
+	w ExternalWriter
+}
+
+var (
+	externalizersMu sync.RWMutex
+	externalizers   = make(map[StringType]externalizer)
+)
+
+// RegisterExternalizable registers the reader and writer used for objects
+// whose traits carry className and the externalizable flag. Both are
+// required; it panics if either is nil.
+func RegisterExternalizable(className StringType, r ExternalReader, w ExternalWriter) {
+	if r == nil || w == nil {
+		panic("amf3: RegisterExternalizable needs a reader and a writer for " + string(className))
+	}
+	externalizersMu.Lock()
+	defer externalizersMu.Unlock()
+	externalizers[className] = externalizer{r: r, w: w}
+}
+
+func lookupExternalizable(className StringType) (externalizer, error) {
+	externalizersMu.RLock()
+	defer externalizersMu.RUnlock()
+	ext, ok := externalizers[className]
+	if !ok {
+		return ext, errors.New("externalizable class not registered: " + string(className))
+	}
+	return ext, nil
+}
+
+// UnwrapExternalizable makes Decode return the value read by the
+// ExternalReader of an externalizable object in place of the object, such
+// as the array a flex.messaging.io.ArrayCollection wraps.
+func (dec *Decoder) UnwrapExternalizable() {
+	dec.unwrap = true
+}
+
+func (dec *Decoder) unwrapped(obj *ObjectType) interface{} {
+	if dec.unwrap && obj.Trait != nil && obj.Trait.IsExternalizable {
+		return obj.External
+	}
+	return obj
+}
@@ -0,0 +1,119 @@
+package amf3
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func init() {
+	RegisterExternalizable("test.Wrapper", func(dec *Decoder) (interface{}, error) {
+		return dec.Decode()
+	}, func(enc *Encoder, v interface{}) error {
+		return enc.EncodeValue(v)
+	})
+	RegisterExternalizable("test.Strings", func(dec *Decoder) (interface{}, error) {
+		v, err := dec.Decode()
+		if err != nil {
+			return nil, err
+		}
+		s, _ := v.(string)
+		return []string{s}, nil
+	}, func(enc *Encoder, v interface{}) error {
+		s, _ := v.([]string)
+		if len(s) != 1 {
+			return fmt.Errorf("cannot write %v as test.Strings", v)
+		}
+		return enc.EncodeValue(StringType(s[0]))
+	})
+}
+
+var externalizableBytes = []byte{0x0a, 0x07, 0x19, 0x74, 0x65, 0x73, 0x74, 0x2e, 0x57, 0x72, 0x61, 0x70, 0x70, 0x65, 0x72, 0x06, 0x07, 0x66, 0x6f, 0x6f,
+	0x0a, 0x01, 0x06, 0x02}
+
+func TestEncodeExternalizable(t *testing.T) {
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+	trait := &Trait{ClassName: "test.Wrapper", IsExternalizable: true}
+	err := enc.Encode(&ObjectType{Trait: trait, External: StringType("foo")})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	err = enc.Encode(&ObjectType{Trait: trait, External: StringType("foo")})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	got := buf.Bytes()
+	if !bytes.Equal(externalizableBytes, got) {
+		t.Errorf("expect %x got %x", externalizableBytes, got)
+	}
+}
+
+func TestDecodeExternalizable(t *testing.T) {
+	dec := NewDecoder(bytes.NewReader(externalizableBytes))
+	for i := 0; i < 2; i++ {
+		got, err := dec.Decode()
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		obj, ok := got.(*ObjectType)
+		if !ok {
+			t.Fatalf("type incorrect")
+		}
+		if !obj.Trait.IsExternalizable || obj.Trait.ClassName != "test.Wrapper" {
+			t.Fatalf("trait incorrect")
+		}
+		if obj.External != StringType("foo") {
+			t.Fatalf("decode incorrect")
+		}
+	}
+}
+
+func TestDecodeExternalizableNotRegistered(t *testing.T) {
+	dec := NewDecoder(bytes.NewReader([]byte{0x0a, 0x07, 0x03, 0x58}))
+	_, err := dec.Decode()
+	if err == nil {
+		t.Fatalf("should report unregistered class")
+	}
+}
+
+func TestDecodeUnwrapExternalizable(t *testing.T) {
+	dec := NewDecoder(bytes.NewReader(externalizableBytes))
+	dec.UnwrapExternalizable()
+	for i := 0; i < 2; i++ {
+		got, err := dec.Decode()
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		if got != StringType("foo") {
+			t.Errorf("expect %v got %#v", StringType("foo"), got)
+		}
+	}
+}
+
+func TestDecodePlainExternalizableSlice(t *testing.T) {
+	data := []byte{0x0a, 0x07, 0x19, 't', 'e', 's', 't', '.', 'S', 't', 'r', 'i', 'n', 'g', 's', 0x06, 0x03, 'a',
+		0x0a, 0x00}
+	dec := NewDecoder(bytes.NewReader(data))
+	dec.UsePlainTypes()
+	for i := 0; i < 2; i++ {
+		got, err := dec.Decode()
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		if s, ok := got.([]string); !ok || len(s) != 1 || s[0] != "a" {
+			t.Errorf("expect [a] got %#v", got)
+		}
+	}
+}
+
+func TestRegisterExternalizableNil(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("should reject a nil writer")
+		}
+	}()
+	RegisterExternalizable("test.NoWriter", func(dec *Decoder) (interface{}, error) {
+		return dec.Decode()
+	}, nil)
+}
@@ -6,25 +6,19 @@ import (
 )
 
 func encodeUInt29(n uint32) ([]byte, error) {
-	var b []byte
+	return appendUInt29(nil, n)
+}
+
+// appendUInt29 appends the encoding of n to b.
+func appendUInt29(b []byte, n uint32) ([]byte, error) {
 	if n <= 0x0000007F {
-		b = make([]byte, 1)
-		b[0] = byte(n)
+		b = append(b, byte(n))
 	} else if n <= 0x00003FFF {
-		b = make([]byte, 2)
-		b[0] = byte(n>>7 | 0x80)
-		b[1] = byte(n & 0x7F)
+		b = append(b, byte(n>>7|0x80), byte(n&0x7F))
 	} else if n <= 0x001FFFFF {
-		b = make([]byte, 3)
-		b[0] = byte(n>>14 | 0x80)
-		b[1] = byte(n>>7&0x7F | 0x80)
-		b[2] = byte(n & 0x7F)
+		b = append(b, byte(n>>14|0x80), byte(n>>7&0x7F|0x80), byte(n&0x7F))
 	} else if n <= 0x1FFFFFFF {
-		b = make([]byte, 4)
-		b[0] = byte(n>>22 | 0x80)
-		b[1] = byte(n>>15&0x7F | 0x80)
-		b[2] = byte(n>>8&0x7F | 0x80)
-		b[3] = byte(n)
+		b = append(b, byte(n>>22|0x80), byte(n>>15&0x7F|0x80), byte(n>>8&0x7F|0x80), byte(n))
 	} else {
 		return nil, errors.New("out of range")
 	}
@@ -45,22 +39,41 @@ func EncodeInt29(w io.Writer, n int32) error {
 	if err != nil {
 		return err
 	}
-	un = un&0xFFFFFFF | (un & 0x80000000 >> 3)
 	return EncodeUInt29(w, un)
 }
 
+// byteReader reads a byte at a time from a reader lacking ReadByte.
+type byteReader struct {
+	io.Reader
+}
+
+func (r byteReader) ReadByte() (byte, error) {
+	b := make([]byte, 1)
+	_, err := io.ReadFull(r.Reader, b)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
 func DecodeUInt29(r io.Reader) (uint32, error) {
 	var n uint32 = 0
 	i := 0
-	b := make([]byte, 1)
+	br, ok := r.(io.ByteReader)
+	if !ok {
+		br = byteReader{r}
+	}
 	for {
-		_, err := r.Read(b)
+		b, err := br.ReadByte()
+		if err == io.EOF && i > 0 {
+			return 0, io.ErrUnexpectedEOF
+		}
 		if err != nil {
 			return 0, err
 		}
 		if i != 3 {
-			n |= uint32(b[0] & 0x7F)
-			if b[0]&0x80 != 0 {
+			n |= uint32(b & 0x7F)
+			if b&0x80 != 0 {
 				if i != 2 {
 					n <<= 7
 				} else {
@@ -70,7 +83,7 @@ func DecodeUInt29(r io.Reader) (uint32, error) {
 				break
 			}
 		} else {
-			n |= uint32(b[0])
+			n |= uint32(b)
 			break
 		}
 		i++
@@ -106,7 +119,7 @@ func U2SInt29(i uint32) (int32, error) {
 		return 0, errors.New("out of range")
 	}
 	if i&0x10000000 != 0 {
-		return int32(i | 0xFF000000), nil
+		return int32(i | 0xE0000000), nil
 	}
 	return int32(i), nil
 }
@@ -57,6 +57,7 @@ var testIntPair = []intPair{
 	{0x01111111, 0x01111111},
 	{0x0FFFFFFF, 0x0FFFFFFF},
 	{0x1FFFFFFF, -0x00000001},
+	{0x10000000, -0x10000000},
 }
 
 func TestS2UInt29(t *testing.T) {
@@ -92,3 +93,20 @@ func TestU2SInt29(t *testing.T) {
 		t.Errorf("test for 0xFFFFFFFF: should report out of range")
 	}
 }
+
+func TestEncodeDecodeInt29(t *testing.T) {
+	for _, pair := range testIntPair {
+		buf := new(bytes.Buffer)
+		err := EncodeInt29(buf, pair.s)
+		if err != nil {
+			t.Errorf("test for %v error: %s", pair.s, err)
+			continue
+		}
+		s, err := DecodeInt29(buf)
+		if err != nil {
+			t.Errorf("test for %v error: %s", pair.s, err)
+		} else if s != pair.s {
+			t.Errorf("test for %v: got %v", pair.s, s)
+		}
+	}
+}
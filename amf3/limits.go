@@ -0,0 +1,53 @@
+package amf3
+
+import (
+	"fmt"
+)
+
+// DecoderLimits bounds the resources a Decoder spends on untrusted input.
+// A zero field means no limit.
+type DecoderLimits struct {
+	MaxDepth            int   // nesting of values, the top-level value being 1
+	MaxStringLength     int   // bytes in a string, XML or ByteArray
+	MaxCollectionLength int   // items or members in an array, object, Vector or Dictionary
+	MaxReferences       int   // entries in each of the string, object and trait reference tables
+	MaxBytes            int64 // bytes read over the life of the decoder, counted from its start
+}
+
+// SetLimits makes the decoder fail with ErrLimitExceeded when the input
+// goes past limits.
+func (dec *Decoder) SetLimits(limits DecoderLimits) {
+	dec.limits = limits
+	dec.r.Limit = limits.MaxBytes
+}
+
+func (dec *Decoder) checkStringLength(n uint32) error {
+	if dec.limits.MaxStringLength > 0 && uint64(n) > uint64(dec.limits.MaxStringLength) {
+		return fmt.Errorf("%w: string length %d", ErrLimitExceeded, n)
+	}
+	return nil
+}
+
+func (dec *Decoder) checkCollectionLength(n uint32) error {
+	if dec.limits.MaxCollectionLength > 0 && uint64(n) > uint64(dec.limits.MaxCollectionLength) {
+		return fmt.Errorf("%w: collection length %d", ErrLimitExceeded, n)
+	}
+	return nil
+}
+
+func (dec *Decoder) checkReferences(n int) error {
+	if dec.limits.MaxReferences > 0 && n >= dec.limits.MaxReferences {
+		return fmt.Errorf("%w: more than %d references", ErrLimitExceeded, dec.limits.MaxReferences)
+	}
+	return nil
+}
+
+// refObject adds obj to the object reference table.
+func (dec *Decoder) refObject(obj interface{}) error {
+	err := dec.checkReferences(len(dec.refObjects))
+	if err != nil {
+		return err
+	}
+	dec.refObjects = append(dec.refObjects, obj)
+	return nil
+}
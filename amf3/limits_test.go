@@ -0,0 +1,59 @@
+package amf3
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestDecoderLimits(t *testing.T) {
+	nested := []byte{0x09, 0x03, 0x01, 0x09, 0x03, 0x01, 0x09, 0x01, 0x01}
+	strings := []byte{0x09, 0x07, 0x01, 0x06, 0x03, 0x61, 0x06, 0x03, 0x62, 0x06, 0x03, 0x63}
+	dynamic := []byte{0x0a, 0x0b, 0x01, 0x03, 0x61, 0x04, 0x01, 0x03, 0x62, 0x04, 0x02, 0x01}
+	tests := []struct {
+		data   []byte
+		limits DecoderLimits
+		err    error
+	}{
+		{nested, DecoderLimits{MaxDepth: 3}, nil},
+		{nested, DecoderLimits{MaxDepth: 2}, ErrLimitExceeded},
+		{[]byte{0x06, 0x07, 0x66, 0x6f, 0x6f}, DecoderLimits{MaxStringLength: 3}, nil},
+		{[]byte{0x06, 0x07, 0x66, 0x6f, 0x6f}, DecoderLimits{MaxStringLength: 2}, ErrLimitExceeded},
+		{[]byte{0x0c, 0xbf, 0xff, 0xff, 0xff}, DecoderLimits{MaxStringLength: 1024}, ErrLimitExceeded},
+		{strings, DecoderLimits{MaxCollectionLength: 3}, nil},
+		{strings, DecoderLimits{MaxCollectionLength: 2}, ErrLimitExceeded},
+		{[]byte{0x10, 0xbf, 0xff, 0xff, 0xff}, DecoderLimits{MaxCollectionLength: 1024}, ErrLimitExceeded},
+		{dynamic, DecoderLimits{MaxCollectionLength: 2}, nil},
+		{dynamic, DecoderLimits{MaxCollectionLength: 1}, ErrLimitExceeded},
+		{strings, DecoderLimits{MaxReferences: 3}, nil},
+		{strings, DecoderLimits{MaxReferences: 2}, ErrLimitExceeded},
+		{strings, DecoderLimits{MaxBytes: 12}, nil},
+		{strings, DecoderLimits{MaxBytes: 11}, ErrLimitExceeded},
+	}
+	for _, test := range tests {
+		dec := NewDecoder(bytes.NewReader(test.data))
+		dec.SetLimits(test.limits)
+		_, err := dec.Decode()
+		if !errors.Is(err, test.err) {
+			t.Errorf("% x with %+v: expect error %v got %v", test.data, test.limits, test.err, err)
+		}
+	}
+}
+
+func TestDecoderMaxBytesTotal(t *testing.T) {
+	buf := bytes.NewReader([]byte{0x06, 0x07, 0x66, 0x6f, 0x6f, 0x06, 0x07, 0x62, 0x61, 0x72})
+	dec := NewDecoder(buf)
+	dec.SetLimits(DecoderLimits{MaxBytes: 8})
+	got, err := dec.Decode()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if got != StringType("foo") {
+		t.Fatalf("expect foo got %v", got)
+	}
+	// the budget covers all values, not each one
+	_, err = dec.Decode()
+	if !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("expect error %v got %v", ErrLimitExceeded, err)
+	}
+}
@@ -0,0 +1,171 @@
+package amf3
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+)
+
+// UsePlainTypes makes Decode return plain Go values instead of the wire
+// types: nil, bool, int, float64, string, time.Time, []byte,
+// []interface{} and map[string]interface{}. Arrays with associative
+// members become maps keyed by index and name, Vectors become []int32,
+// []uint32, []float64 or []interface{}, and Dictionaries become
+// map[interface{}]interface{}. Objects decoded more than once by reference
+// come back as the same map or slice.
+func (dec *Decoder) UsePlainTypes() {
+	dec.plain = true
+	if dec.plainRefs == nil {
+		dec.plainRefs = make(map[interface{}]interface{})
+	}
+}
+
+// DecodeWire decodes the next value into the wire types even after
+// UsePlainTypes, for ExternalReaders and Unmarshalers that read them.
+func (dec *Decoder) DecodeWire() (interface{}, error) {
+	return dec.decodeValue()
+}
+
+func (dec *Decoder) toPlain(v interface{}) (interface{}, error) {
+	switch value := v.(type) {
+	case UndefinedType, NullType:
+		return nil, nil
+	case FalseType:
+		return false, nil
+	case TrueType:
+		return true, nil
+	case IntegerType:
+		return int(value), nil
+	case DoubleType:
+		return float64(value), nil
+	case StringType:
+		return string(value), nil
+	case *XMLDocumentType:
+		return string(*value), nil
+	case *XMLType:
+		return string(*value), nil
+	case *DateType:
+		return value.Time(), nil
+	}
+	if !isWireRef(v) {
+		// already plain, as returned by Decode inside an ExternalReader, or
+		// any other value an ExternalReader returns
+		return v, nil
+	}
+	if plain, ok := dec.plainRefs[v]; ok {
+		return plain, nil
+	}
+	switch value := v.(type) {
+	case *ByteArrayType:
+		b := []byte(*value)
+		dec.plainRefs[v] = b
+		return b, nil
+	case *VectorIntType:
+		dec.plainRefs[v] = value.Items
+		return value.Items, nil
+	case *VectorUintType:
+		dec.plainRefs[v] = value.Items
+		return value.Items, nil
+	case *VectorDoubleType:
+		dec.plainRefs[v] = value.Items
+		return value.Items, nil
+	case *VectorObjectType:
+		return dec.sliceToPlain(v, value.Items)
+	case *ArrayType:
+		if len(value.Associative) == 0 {
+			return dec.sliceToPlain(v, value.Dense)
+		}
+		m := make(map[string]interface{}, len(value.Dense)+len(value.Associative))
+		dec.plainRefs[v] = m
+		for i, item := range value.Dense {
+			plain, err := dec.toPlain(item)
+			if err != nil {
+				return nil, err
+			}
+			m[strconv.Itoa(i)] = plain
+		}
+		for k, item := range value.Associative {
+			plain, err := dec.toPlain(item)
+			if err != nil {
+				return nil, err
+			}
+			m[string(k)] = plain
+		}
+		return m, nil
+	case *ObjectType:
+		if value.Trait != nil && value.Trait.IsExternalizable {
+			plain, err := dec.toPlain(value.External)
+			if err != nil {
+				return nil, err
+			}
+			dec.plainRefs[v] = plain
+			return plain, nil
+		}
+		m := make(map[string]interface{}, len(value.Static)+len(value.Dynamic))
+		dec.plainRefs[v] = m
+		if value.Trait != nil {
+			for i, attr := range value.Trait.Attrs {
+				plain, err := dec.toPlain(value.Static[i])
+				if err != nil {
+					return nil, err
+				}
+				m[string(attr)] = plain
+			}
+		}
+		for k, item := range value.Dynamic {
+			plain, err := dec.toPlain(item)
+			if err != nil {
+				return nil, err
+			}
+			m[string(k)] = plain
+		}
+		return m, nil
+	case *DictionaryType:
+		m := make(map[interface{}]interface{}, len(value.Entries))
+		dec.plainRefs[v] = m
+		for _, entry := range value.Entries {
+			key, err := dec.toPlain(entry.Key)
+			if err != nil {
+				return nil, err
+			}
+			if !isComparable(key) {
+				return nil, errors.New("dictionary key is not comparable")
+			}
+			plain, err := dec.toPlain(entry.Value)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = plain
+		}
+		return m, nil
+	}
+	return v, nil
+}
+
+func (dec *Decoder) sliceToPlain(ref interface{}, items []interface{}) ([]interface{}, error) {
+	s := make([]interface{}, len(items))
+	dec.plainRefs[ref] = s
+	for i, item := range items {
+		plain, err := dec.toPlain(item)
+		if err != nil {
+			return nil, err
+		}
+		s[i] = plain
+	}
+	return s, nil
+}
+
+// isWireRef reports whether v is a wire value that can be shared by
+// reference, and so keys plainRefs.
+func isWireRef(v interface{}) bool {
+	switch v.(type) {
+	case *ObjectType, *ArrayType, *ByteArrayType, *DictionaryType,
+		*VectorIntType, *VectorUintType, *VectorDoubleType, *VectorObjectType:
+		return true
+	}
+	return false
+}
+
+func isComparable(v interface{}) bool {
+	return v == nil || reflect.TypeOf(v).Comparable()
+}
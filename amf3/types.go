@@ -12,8 +12,14 @@ type FalseType struct {
 type TrueType struct {
 }
 
-type IntegerType uint32
+type IntegerType int32
 type DoubleType float64
+
+const (
+	MaxInteger = 0x0FFFFFFF  // largest value of IntegerMarker
+	MinInteger = -0x10000000 // smallest value of IntegerMarker
+)
+
 type StringType string
 type XMLDocumentType string
 type DateType float64
@@ -23,16 +29,61 @@ type ArrayType struct {
 }
 
 type Trait struct {
-	ClassName StringType
-	IsDynamic bool
-	Attrs     []StringType
+	ClassName        StringType
+	IsDynamic        bool
+	IsExternalizable bool
+	Attrs            []StringType
 }
 
 type ObjectType struct {
-	Trait   *Trait
-	Static  []interface{}
-	Dynamic map[StringType]interface{}
+	Trait    *Trait
+	Static   []interface{}
+	Dynamic  map[StringType]interface{}
+	External interface{} // body of an externalizable object
 }
 
 type XMLType string
 type ByteArrayType []byte
+
+type VectorIntType struct {
+	Fixed bool
+	Items []int32
+}
+
+type VectorUintType struct {
+	Fixed bool
+	Items []uint32
+}
+
+type VectorDoubleType struct {
+	Fixed bool
+	Items []float64
+}
+
+type VectorObjectType struct {
+	TypeName StringType
+	Fixed    bool
+	Items    []interface{}
+}
+
+type DictionaryEntry struct {
+	Key   interface{}
+	Value interface{}
+}
+
+type DictionaryType struct {
+	WeakKeys bool
+	Entries  []DictionaryEntry
+}
+
+// Marshaler is implemented by types that encode themselves through the
+// Encoder, usually as a single value written with EncodeValue.
+type Marshaler interface {
+	MarshalAMF3(enc *Encoder) error
+}
+
+// Unmarshaler is implemented by types that decode themselves from the
+// value produced by their MarshalAMF3.
+type Unmarshaler interface {
+	UnmarshalAMF3(dec *Decoder) error
+}
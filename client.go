@@ -0,0 +1,138 @@
+package amf
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/hongruiqi/amf.go/amf0"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// RemotingClient calls the methods of a Flash Remoting gateway, as
+// NetConnection.call does.
+type RemotingClient struct {
+	URL        string
+	Version    uint16   // AMF0, or AMF3 to send call arguments in AMF3
+	Headers    []Header // sent with every request
+	HTTPClient *http.Client
+	Limits     amf0.DecoderLimits // bound each value of a response
+}
+
+// Call is a call made through a RemotingClient.
+type Call struct {
+	Target string        // "Service.method"
+	Args   []interface{} // encoded as by Marshal
+	Result interface{}   // pointer the result is stored into as by Unmarshal, or nil
+	Error  error         // set when the call fails, to a *Fault for an onStatus response
+}
+
+// Call calls target with args and stores its result into the value pointed
+// to by result, which may be nil to drop it. A call answered with onStatus
+// fails with a *Fault.
+func (c *RemotingClient) Call(ctx context.Context, target string, result interface{}, args ...interface{}) error {
+	call := &Call{Target: target, Args: args, Result: result}
+	err := c.Do(ctx, call)
+	if err != nil {
+		return err
+	}
+	return call.Error
+}
+
+// Do sends calls together in one request and sets the Result or Error of
+// each. It returns an error only when the request as a whole fails.
+func (c *RemotingClient) Do(ctx context.Context, calls ...*Call) error {
+	req := &Packet{Version: c.Version, Headers: c.Headers}
+	for i, call := range calls {
+		if call.Result != nil {
+			rv := reflect.ValueOf(call.Result)
+			if rv.Kind() != reflect.Ptr || rv.IsNil() {
+				return errors.New("amf: call result needs a non-nil pointer")
+			}
+		}
+		args := call.Args
+		if args == nil {
+			args = []interface{}{}
+		}
+		body, err := marshalBody(args, c.Version)
+		if err != nil {
+			return err
+		}
+		req.Messages = append(req.Messages, Message{
+			TargetURI:   call.Target,
+			ResponseURI: "/" + strconv.Itoa(i+1),
+			Body:        body,
+		})
+	}
+	data, err := AppendPacket(nil, req)
+	if err != nil {
+		return err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/x-amf")
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	httpResp, err := httpClient.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		return errors.New("amf: gateway answered " + httpResp.Status)
+	}
+	resp, err := readPacket(httpResp.Body, c.Limits)
+	if err != nil {
+		return err
+	}
+	answered := make([]bool, len(calls))
+	for _, m := range resp.Messages {
+		// targets are "/1/onResult" or "/1/onStatus"
+		slash := strings.LastIndexByte(m.TargetURI, '/')
+		if slash < 1 {
+			continue
+		}
+		i, err := strconv.Atoi(m.TargetURI[1:slash])
+		if err != nil || i < 1 || i > len(calls) || answered[i-1] {
+			continue
+		}
+		answered[i-1] = true
+		call := calls[i-1]
+		// a Call may be reused, so clear the error of its last response
+		call.Error = nil
+		switch m.TargetURI[slash:] {
+		case "/onResult":
+			if call.Result != nil {
+				u := &unmarshaler{seen: make(map[unmarshalKey]reflect.Value)}
+				call.Error = u.assign(reflect.ValueOf(call.Result).Elem(), m.Body)
+			}
+		case "/onStatus":
+			call.Error = toFault(m.Body)
+		default:
+			call.Error = errors.New("amf: unexpected response " + m.TargetURI)
+		}
+	}
+	for i, call := range calls {
+		if !answered[i] {
+			call.Error = errors.New("amf: no response for " + call.Target)
+		}
+	}
+	return nil
+}
+
+// toFault converts the body of an onStatus response.
+func toFault(body interface{}) *Fault {
+	fault := new(Fault)
+	u := &unmarshaler{seen: make(map[unmarshalKey]reflect.Value)}
+	err := u.assign(reflect.ValueOf(fault).Elem(), body)
+	if err != nil || fault.Code == "" && fault.Description == "" {
+		return newFault("Client.Call.Failed", "unrecognized status")
+	}
+	return fault
+}
@@ -0,0 +1,127 @@
+package amf
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newTestGateway(t *testing.T) (*httptest.Server, *int) {
+	g := new(Gateway)
+	err := g.Register("Service", testService{})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	requests := new(int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests++
+		g.ServeHTTP(w, r)
+	}))
+	return server, requests
+}
+
+func TestRemotingClientCall(t *testing.T) {
+	server, _ := newTestGateway(t)
+	defer server.Close()
+	for _, version := range []uint16{AMF0, AMF3} {
+		c := &RemotingClient{URL: server.URL, Version: version}
+		var sum int
+		err := c.Call(context.Background(), "Service.add", &sum, 1, 2)
+		if err != nil {
+			t.Fatalf("version %d: %s", version, err)
+		}
+		if sum != 3 {
+			t.Errorf("version %d: expect 3 got %d", version, sum)
+		}
+		var greeting string
+		err = c.Call(context.Background(), "Service.greet", &greeting, testUser{Name: "foo"})
+		if err != nil {
+			t.Fatalf("version %d: %s", version, err)
+		}
+		if greeting != "hello foo" {
+			t.Errorf("version %d: expect %q got %q", version, "hello foo", greeting)
+		}
+		err = c.Call(context.Background(), "Service.fail", nil)
+		var fault *Fault
+		if !errors.As(err, &fault) || fault.Code != "Server.Call.Failed" || fault.Description != "broken" {
+			t.Errorf("version %d: expect Server.Call.Failed fault got %v", version, err)
+		}
+	}
+}
+
+func TestRemotingClientBatch(t *testing.T) {
+	server, requests := newTestGateway(t)
+	defer server.Close()
+	c := &RemotingClient{URL: server.URL}
+	var echo string
+	var sum int
+	calls := []*Call{
+		{Target: "Service.echo", Args: []interface{}{"foo"}, Result: &echo},
+		{Target: "Service.deny"},
+		{Target: "Service.add", Args: []interface{}{2, 3}, Result: &sum},
+	}
+	err := c.Do(context.Background(), calls...)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if *requests != 1 {
+		t.Errorf("expect 1 request got %d", *requests)
+	}
+	if calls[0].Error != nil || echo != "foo" {
+		t.Errorf("echo: got %q, %v", echo, calls[0].Error)
+	}
+	if fault, ok := calls[1].Error.(*Fault); !ok || fault.Code != "Client.Auth" {
+		t.Errorf("deny: expect Client.Auth fault got %v", calls[1].Error)
+	}
+	if calls[2].Error != nil || sum != 5 {
+		t.Errorf("add: got %d, %v", sum, calls[2].Error)
+	}
+}
+
+func TestRemotingClientReuseCall(t *testing.T) {
+	server, _ := newTestGateway(t)
+	defer server.Close()
+	c := &RemotingClient{URL: server.URL}
+	call := &Call{Target: "Service.fail"}
+	err := c.Do(context.Background(), call)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if call.Error == nil {
+		t.Fatalf("fail: expect an error")
+	}
+	var sum int
+	call.Target, call.Args, call.Result = "Service.add", []interface{}{1, 2}, &sum
+	err = c.Do(context.Background(), call)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if call.Error != nil || sum != 3 {
+		t.Errorf("add: got %d, %v", sum, call.Error)
+	}
+	call.Target, call.Args, call.Result = "Service.fail", nil, nil
+	c.Do(context.Background(), call)
+	call.Target, call.Args = "Service.echo", []interface{}{"foo"}
+	err = c.Do(context.Background(), call)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if call.Error != nil {
+		t.Errorf("echo without result: expect no error got %v", call.Error)
+	}
+}
+
+func TestRemotingClientHTTPError(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	c := &RemotingClient{URL: server.URL}
+	err := c.Call(context.Background(), "Service.echo", nil, "foo")
+	if err == nil {
+		t.Errorf("expect error for status 404")
+	}
+	if err = c.Call(context.Background(), "Service.echo", 1); err == nil {
+		t.Errorf("expect error for a non-pointer result")
+	}
+}
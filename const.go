@@ -0,0 +1,7 @@
+package amf
+
+// AMF object encoding versions.
+const (
+	AMF0 = 0
+	AMF3 = 3
+)
@@ -1 +1,518 @@
 package amf
+
+import (
+	"bytes"
+	"errors"
+	"github.com/hongruiqi/amf.go/amf0"
+	"github.com/hongruiqi/amf.go/amf3"
+	"io"
+	"math"
+	"reflect"
+	"strconv"
+)
+
+// Unmarshal decodes the AMF value of the given version in data and stores
+// the result in the value pointed to by v.
+//
+// Objects are stored into structs by member name, using the same
+// `amf:"name"` tags as Marshal, or into maps with string keys. Values stored
+// into an interface{} become bool, float64, int, string, time.Time, []byte,
+// []interface{} or map[string]interface{}. Externalizable objects such as a
+// Flex ArrayCollection are stored as the value they wrap. Values implementing
+// amf0.Unmarshaler or amf3.Unmarshaler decode themselves.
+func Unmarshal(data []byte, v interface{}, version int) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("amf: Unmarshal needs a non-nil pointer")
+	}
+	var src interface{}
+	var err error
+	switch version {
+	case AMF0:
+		src, err = amf0.NewDecoder(bytes.NewReader(data)).Decode()
+	case AMF3:
+		src, err = amf3.NewDecoder(bytes.NewReader(data)).Decode()
+	default:
+		return errors.New("amf: unsupported version")
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return err
+	}
+	u := &unmarshaler{seen: make(map[unmarshalKey]reflect.Value)}
+	return u.assign(rv.Elem(), src)
+}
+
+var (
+	unmarshaler0Type = reflect.TypeOf((*amf0.Unmarshaler)(nil)).Elem()
+	unmarshaler3Type = reflect.TypeOf((*amf3.Unmarshaler)(nil)).Elem()
+)
+
+type unmarshalKey struct {
+	src interface{}
+	typ reflect.Type
+}
+
+type unmarshaler struct {
+	seen map[unmarshalKey]reflect.Value // wire objects already converted, to keep references
+}
+
+// callUnmarshaler lets dst decode itself from src when it implements
+// amf0.Unmarshaler or amf3.Unmarshaler, matching the version src was read
+// with. src is encoded again so the Unmarshaler reads it from a Decoder.
+func callUnmarshaler(dst reflect.Value, src interface{}) (bool, error) {
+	if isWireType(reflect.TypeOf(src), amf3PkgPath) {
+		um, ok := implementer(dst, unmarshaler3Type)
+		if !ok {
+			return false, nil
+		}
+		buf := new(bytes.Buffer)
+		err := amf3.NewEncoder(buf).Encode(src)
+		if err != nil {
+			return true, err
+		}
+		return true, um.(amf3.Unmarshaler).UnmarshalAMF3(amf3.NewDecoder(buf))
+	}
+	um, ok := implementer(dst, unmarshaler0Type)
+	if !ok {
+		return false, nil
+	}
+	buf := new(bytes.Buffer)
+	err := amf0.NewEncoder(buf).Encode(src)
+	if err != nil {
+		return true, err
+	}
+	return true, um.(amf0.Unmarshaler).UnmarshalAMF0(amf0.NewDecoder(buf))
+}
+
+func typeError(src interface{}, t reflect.Type) error {
+	return errors.New("amf: cannot unmarshal " + reflect.TypeOf(src).String() + " into Go value of type " + t.String())
+}
+
+func isNull(src interface{}) bool {
+	switch src.(type) {
+	case nil, amf0.NullType, amf0.UndefinedType, amf3.NullType, amf3.UndefinedType:
+		return true
+	}
+	return false
+}
+
+// isRef reports whether src is a wire value that can be shared by reference.
+func isRef(src interface{}) bool {
+	return src != nil && reflect.TypeOf(src).Kind() == reflect.Ptr
+}
+
+func (u *unmarshaler) assign(dst reflect.Value, src interface{}) error {
+	t := dst.Type()
+	if isNull(src) {
+		dst.Set(reflect.Zero(t))
+		return nil
+	}
+	if t.Kind() != reflect.Interface && t.Kind() != reflect.Ptr && dst.CanAddr() {
+		ok, err := callUnmarshaler(dst, src)
+		if ok {
+			return err
+		}
+	}
+	if t.Kind() != reflect.Interface && reflect.TypeOf(src).AssignableTo(t) {
+		dst.Set(reflect.ValueOf(src))
+		return nil
+	}
+	if isRef(src) {
+		if v, ok := u.seen[unmarshalKey{src, t}]; ok {
+			dst.Set(v)
+			return nil
+		}
+	}
+	switch t.Kind() {
+	case reflect.Interface:
+		v, err := u.natural(src)
+		if err != nil {
+			return err
+		}
+		if v == nil {
+			dst.Set(reflect.Zero(t))
+			return nil
+		}
+		rv := reflect.ValueOf(v)
+		if !rv.Type().AssignableTo(t) {
+			return typeError(src, t)
+		}
+		dst.Set(rv)
+		return nil
+	case reflect.Ptr:
+		p := reflect.New(t.Elem())
+		if isRef(src) {
+			u.seen[unmarshalKey{src, t}] = p
+		}
+		err := u.assign(p.Elem(), src)
+		if err != nil {
+			return err
+		}
+		dst.Set(p)
+		return nil
+	}
+	switch s := src.(type) {
+	case amf0.BooleanType:
+		return setBool(dst, src, bool(s))
+	case amf3.TrueType:
+		return setBool(dst, src, true)
+	case amf3.FalseType:
+		return setBool(dst, src, false)
+	case amf0.NumberType:
+		return setFloat(dst, src, float64(s))
+	case amf3.DoubleType:
+		return setFloat(dst, src, float64(s))
+	case amf3.IntegerType:
+		return setFloat(dst, src, float64(s))
+	case amf0.StringType:
+		return setString(dst, src, string(s))
+	case amf0.LongStringType:
+		return setString(dst, src, string(s))
+	case amf0.XmlDocumentType:
+		return setString(dst, src, string(s))
+	case amf3.StringType:
+		return setString(dst, src, string(s))
+	case *amf3.XMLType:
+		return setString(dst, src, string(*s))
+	case *amf3.XMLDocumentType:
+		return setString(dst, src, string(*s))
+	case amf0.DateType:
+		return setTime(dst, src, s.Date)
+	case *amf3.DateType:
+		return setTime(dst, src, float64(*s))
+	case *amf3.ByteArrayType:
+		if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+			dst.SetBytes(append([]byte(nil), *s...))
+			return nil
+		}
+		return typeError(src, t)
+	case *amf0.StrictArrayType:
+		return u.assignSlice(dst, src, []interface{}(*s))
+	case *amf3.ArrayType:
+		if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+			return u.assignSlice(dst, src, s.Dense)
+		}
+		return u.assignMembers(dst, src, members(src))
+	case *amf3.VectorIntType:
+		values := make([]interface{}, len(s.Items))
+		for i, item := range s.Items {
+			values[i] = amf3.IntegerType(item)
+		}
+		return u.assignSlice(dst, src, values)
+	case *amf3.VectorUintType:
+		values := make([]interface{}, len(s.Items))
+		for i, item := range s.Items {
+			values[i] = amf3.DoubleType(item)
+		}
+		return u.assignSlice(dst, src, values)
+	case *amf3.VectorDoubleType:
+		values := make([]interface{}, len(s.Items))
+		for i, item := range s.Items {
+			values[i] = amf3.DoubleType(item)
+		}
+		return u.assignSlice(dst, src, values)
+	case *amf3.VectorObjectType:
+		return u.assignSlice(dst, src, s.Items)
+	case *amf0.ObjectType, *amf0.EcmaArrayType, *amf0.TypedObjectType:
+		return u.assignMembers(dst, src, members(src))
+	case *amf3.ObjectType:
+		if s.Trait != nil && s.Trait.IsExternalizable {
+			// a wrapper such as an ArrayCollection stands for what it wraps
+			return u.assign(dst, s.External)
+		}
+		return u.assignMembers(dst, src, members(src))
+	case *amf3.DictionaryType:
+		if t.Kind() != reflect.Map {
+			return typeError(src, t)
+		}
+		m := reflect.MakeMap(t)
+		u.seen[unmarshalKey{src, t}] = m
+		for _, entry := range s.Entries {
+			k := reflect.New(t.Key()).Elem()
+			err := u.assign(k, entry.Key)
+			if err != nil {
+				return err
+			}
+			if k.Kind() == reflect.Interface && !k.IsNil() && !k.Elem().Type().Comparable() {
+				return errors.New("amf: dictionary key is not comparable")
+			}
+			v := reflect.New(t.Elem()).Elem()
+			err = u.assign(v, entry.Value)
+			if err != nil {
+				return err
+			}
+			m.SetMapIndex(k, v)
+		}
+		dst.Set(m)
+		return nil
+	}
+	return typeError(src, t)
+}
+
+func setBool(dst reflect.Value, src interface{}, b bool) error {
+	if dst.Kind() != reflect.Bool {
+		return typeError(src, dst.Type())
+	}
+	dst.SetBool(b)
+	return nil
+}
+
+func setFloat(dst reflect.Value, src interface{}, f float64) error {
+	switch dst.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i := int64(f)
+		if float64(i) != f || dst.OverflowInt(i) {
+			return errors.New("amf: number " + strconv.FormatFloat(f, 'g', -1, 64) + " overflows " + dst.Type().String())
+		}
+		dst.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		i := uint64(f)
+		if f < 0 || float64(i) != f || dst.OverflowUint(i) {
+			return errors.New("amf: number " + strconv.FormatFloat(f, 'g', -1, 64) + " overflows " + dst.Type().String())
+		}
+		dst.SetUint(i)
+	case reflect.Float32, reflect.Float64:
+		if dst.OverflowFloat(f) && !math.IsInf(f, 0) {
+			return errors.New("amf: number " + strconv.FormatFloat(f, 'g', -1, 64) + " overflows " + dst.Type().String())
+		}
+		dst.SetFloat(f)
+	default:
+		return typeError(src, dst.Type())
+	}
+	return nil
+}
+
+func setString(dst reflect.Value, src interface{}, s string) error {
+	if dst.Kind() != reflect.String {
+		return typeError(src, dst.Type())
+	}
+	dst.SetString(s)
+	return nil
+}
+
+func setTime(dst reflect.Value, src interface{}, ms float64) error {
+	if dst.Type() != timeType {
+		return typeError(src, dst.Type())
+	}
+	dst.Set(reflect.ValueOf(amf3.DateType(ms).Time()))
+	return nil
+}
+
+func (u *unmarshaler) assignSlice(dst reflect.Value, src interface{}, values []interface{}) error {
+	t := dst.Type()
+	switch t.Kind() {
+	case reflect.Slice:
+		slice := reflect.MakeSlice(t, len(values), len(values))
+		u.seen[unmarshalKey{src, t}] = slice
+		for i, value := range values {
+			err := u.assign(slice.Index(i), value)
+			if err != nil {
+				return err
+			}
+		}
+		dst.Set(slice)
+	case reflect.Array:
+		if len(values) > t.Len() {
+			return errors.New("amf: array too long for " + t.String())
+		}
+		for i, value := range values {
+			err := u.assign(dst.Index(i), value)
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		return typeError(src, t)
+	}
+	return nil
+}
+
+// className returns the class alias carried by a typed object.
+func className(src interface{}) string {
+	switch s := src.(type) {
+	case *amf0.TypedObjectType:
+		return string(s.ClassName)
+	case *amf3.ObjectType:
+		if s.Trait != nil {
+			return string(s.Trait.ClassName)
+		}
+	}
+	return ""
+}
+
+// member is a name/value pair of an AMF object.
+type member struct {
+	name  string
+	value interface{}
+}
+
+// members lists the sealed and dynamic members of an object-like wire value.
+func members(src interface{}) []member {
+	var ms []member
+	switch s := src.(type) {
+	case *amf0.ObjectType:
+		for k, v := range *s {
+			ms = append(ms, member{string(k), v})
+		}
+	case *amf0.EcmaArrayType:
+		for k, v := range *s {
+			ms = append(ms, member{string(k), v})
+		}
+	case *amf0.TypedObjectType:
+		for k, v := range s.Object {
+			ms = append(ms, member{string(k), v})
+		}
+	case *amf3.ObjectType:
+		if s.Trait != nil {
+			for i, attr := range s.Trait.Attrs {
+				if i < len(s.Static) {
+					ms = append(ms, member{string(attr), s.Static[i]})
+				}
+			}
+		}
+		for k, v := range s.Dynamic {
+			ms = append(ms, member{string(k), v})
+		}
+	case *amf3.ArrayType:
+		for i, v := range s.Dense {
+			ms = append(ms, member{strconv.Itoa(i), v})
+		}
+		for k, v := range s.Associative {
+			ms = append(ms, member{string(k), v})
+		}
+	}
+	return ms
+}
+
+func (u *unmarshaler) assignMembers(dst reflect.Value, src interface{}, ms []member) error {
+	t := dst.Type()
+	switch t.Kind() {
+	case reflect.Struct:
+		fields := cachedFields(t)
+		for _, m := range ms {
+			f := fieldByName(fields, m.name)
+			if f == nil {
+				continue
+			}
+			err := u.assign(dst.FieldByIndex(f.index), m.value)
+			if err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return typeError(src, t)
+		}
+		m := reflect.MakeMap(t)
+		u.seen[unmarshalKey{src, t}] = m
+		for _, mb := range ms {
+			v := reflect.New(t.Elem()).Elem()
+			err := u.assign(v, mb.value)
+			if err != nil {
+				return err
+			}
+			m.SetMapIndex(reflect.ValueOf(mb.name).Convert(t.Key()), v)
+		}
+		dst.Set(m)
+	default:
+		return typeError(src, t)
+	}
+	return nil
+}
+
+// natural converts src into the plain Go value stored in an interface{}.
+func (u *unmarshaler) natural(src interface{}) (interface{}, error) {
+	if isNull(src) {
+		return nil, nil
+	}
+	if obj, ok := src.(*amf3.ObjectType); ok && obj.Trait != nil && obj.Trait.IsExternalizable {
+		if _, ok := typeByAlias(className(src)); !ok {
+			return u.natural(obj.External)
+		}
+	}
+	switch s := src.(type) {
+	case amf0.BooleanType:
+		return bool(s), nil
+	case amf3.TrueType:
+		return true, nil
+	case amf3.FalseType:
+		return false, nil
+	case amf0.NumberType:
+		return float64(s), nil
+	case amf3.DoubleType:
+		return float64(s), nil
+	case amf3.IntegerType:
+		return int(s), nil
+	case amf0.StringType:
+		return string(s), nil
+	case amf0.LongStringType:
+		return string(s), nil
+	case amf0.XmlDocumentType:
+		return string(s), nil
+	case amf3.StringType:
+		return string(s), nil
+	case *amf3.XMLType:
+		return string(*s), nil
+	case *amf3.XMLDocumentType:
+		return string(*s), nil
+	case amf0.DateType:
+		return amf3.DateType(s.Date).Time(), nil
+	case *amf3.DateType:
+		return s.Time(), nil
+	case *amf3.ByteArrayType:
+		return append([]byte(nil), *s...), nil
+	case *amf3.VectorIntType:
+		return append([]int32(nil), s.Items...), nil
+	case *amf3.VectorUintType:
+		return append([]uint32(nil), s.Items...), nil
+	case *amf3.VectorDoubleType:
+		return append([]float64(nil), s.Items...), nil
+	}
+	var v reflect.Value
+	switch s := src.(type) {
+	case *amf0.StrictArrayType:
+		v = reflect.New(reflect.TypeOf([]interface{}(nil))).Elem()
+		err := u.assign(v, src)
+		if err != nil {
+			return nil, err
+		}
+	case *amf3.VectorObjectType:
+		v = reflect.New(reflect.TypeOf([]interface{}(nil))).Elem()
+		err := u.assign(v, src)
+		if err != nil {
+			return nil, err
+		}
+	case *amf3.ArrayType:
+		if len(s.Associative) == 0 {
+			v = reflect.New(reflect.TypeOf([]interface{}(nil))).Elem()
+		} else {
+			v = reflect.New(reflect.TypeOf(map[string]interface{}(nil))).Elem()
+		}
+		err := u.assign(v, src)
+		if err != nil {
+			return nil, err
+		}
+	case *amf0.ObjectType, *amf0.EcmaArrayType, *amf0.TypedObjectType, *amf3.ObjectType:
+		if t, ok := typeByAlias(className(src)); ok {
+			v = reflect.New(reflect.PtrTo(t)).Elem()
+		} else {
+			v = reflect.New(reflect.TypeOf(map[string]interface{}(nil))).Elem()
+		}
+		err := u.assign(v, src)
+		if err != nil {
+			return nil, err
+		}
+	case *amf3.DictionaryType:
+		v = reflect.New(reflect.TypeOf(map[interface{}]interface{}(nil))).Elem()
+		err := u.assign(v, src)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return src, nil
+	}
+	return v.Interface(), nil
+}
@@ -0,0 +1,96 @@
+package amf
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testRecord struct {
+	Name    string            `amf:"name"`
+	Tags    []string          `amf:"tags"`
+	Attrs   map[string]int    `amf:"attrs"`
+	Created time.Time         `amf:"created"`
+	Owner   *testUser         `amf:"owner"`
+	Extra   interface{}       `amf:"extra"`
+	Data    []byte            `amf:"data"`
+	Meta    map[string]string `amf:"meta,omitempty"`
+}
+
+func TestMarshalUnmarshal(t *testing.T) {
+	expect := testRecord{
+		Name:    "foo",
+		Tags:    []string{"a", "b"},
+		Attrs:   map[string]int{"x": 1},
+		Created: time.Unix(1234567890, 123000000),
+		Owner:   &testUser{Name: "bar", Age: 42},
+		Extra:   []interface{}{"baz", 1.5},
+	}
+	for _, version := range []int{AMF0, AMF3} {
+		data, err := Marshal(&expect, version)
+		if err != nil {
+			t.Fatalf("version %d: %s", version, err)
+		}
+		var got testRecord
+		err = Unmarshal(data, &got, version)
+		if err != nil {
+			t.Fatalf("version %d: %s", version, err)
+		}
+		if !got.Created.Equal(expect.Created) {
+			t.Errorf("version %d: expect %v got %v", version, expect.Created, got.Created)
+		}
+		got.Created = expect.Created
+		if !reflect.DeepEqual(expect, got) {
+			t.Errorf("version %d: expect %+v got %+v", version, expect, got)
+		}
+	}
+}
+
+func TestUnmarshalInterface(t *testing.T) {
+	data, err := Marshal(map[string]interface{}{"foo": []interface{}{1, "bar"}}, AMF3)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	var got interface{}
+	err = Unmarshal(data, &got, AMF3)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	expect := map[string]interface{}{"foo": []interface{}{1, "bar"}}
+	if !reflect.DeepEqual(expect, got) {
+		t.Errorf("expect %v got %v", expect, got)
+	}
+}
+
+func TestUnmarshalReference(t *testing.T) {
+	type node struct {
+		Name string
+		Next *node
+	}
+	n := &node{Name: "foo"}
+	n.Next = n
+	data, err := Marshal(n, AMF3)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	var got *node
+	err = Unmarshal(data, &got, AMF3)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if got.Name != "foo" || got.Next != got {
+		t.Errorf("reference not kept: %+v", got)
+	}
+}
+
+func TestUnmarshalTypeError(t *testing.T) {
+	data, err := Marshal("foo", AMF3)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	var got int
+	err = Unmarshal(data, &got, AMF3)
+	if err == nil {
+		t.Errorf("should report type error")
+	}
+}
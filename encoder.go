@@ -0,0 +1,393 @@
+package amf
+
+import (
+	"bytes"
+	"errors"
+	"github.com/hongruiqi/amf.go/amf0"
+	"github.com/hongruiqi/amf.go/amf3"
+	"reflect"
+	"time"
+)
+
+// longest string written with StringMarker in AMF0
+const maxStringLength = 0xFFFF
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	amf0PkgPath    = reflect.TypeOf(amf0.NullType{}).PkgPath()
+	amf3PkgPath    = reflect.TypeOf(amf3.NullType{}).PkgPath()
+	marshaler0Type = reflect.TypeOf((*amf0.Marshaler)(nil)).Elem()
+	marshaler3Type = reflect.TypeOf((*amf3.Marshaler)(nil)).Elem()
+)
+
+// Marshal returns the AMF encoding of v in the given version.
+//
+// Structs are encoded as sealed AMF3 traits or AMF0 objects, with member
+// names taken from `amf:"name,omitempty"` tags. Maps with string keys become
+// anonymous objects, slices become arrays, time.Time becomes a date and
+// values of the amf0 or amf3 wire types are written as is. Values
+// implementing amf0.Marshaler or amf3.Marshaler encode themselves; in AMF0,
+// amf3 values and Marshalers switch to AMF3 through AVM+. A
+// flex.ArrayCollection, ArrayList or ObjectProxy wraps the encoding of its
+// value, which AMF0 sends unwrapped.
+func Marshal(v interface{}, version int) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	m := &marshaler{seen: make(map[seenKey]interface{})}
+	switch version {
+	case AMF0:
+		value, err := m.toAMF0(reflect.ValueOf(v))
+		if err != nil {
+			return nil, err
+		}
+		err = amf0.NewEncoder(buf).Encode(value)
+		if err != nil {
+			return nil, err
+		}
+	case AMF3:
+		value, err := m.toAMF3(reflect.ValueOf(v))
+		if err != nil {
+			return nil, err
+		}
+		err = amf3.NewEncoder(buf).Encode(value)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("amf: unsupported version")
+	}
+	return buf.Bytes(), nil
+}
+
+type seenKey struct {
+	ptr uintptr
+	len int // of a slice, which may share its address with a shorter one
+	typ reflect.Type
+}
+
+type marshaler struct {
+	seen map[seenKey]interface{} // pointers, maps and slices already converted, to keep references
+}
+
+func isWireType(t reflect.Type, pkgPath string) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.PkgPath() == pkgPath
+}
+
+// implementer returns v, or its address, as a value implementing it.
+func implementer(v reflect.Value, it reflect.Type) (interface{}, bool) {
+	if v.Type().Implements(it) && v.CanInterface() {
+		return v.Interface(), true
+	}
+	if v.CanAddr() && v.Addr().Type().Implements(it) && v.Addr().CanInterface() {
+		return v.Addr().Interface(), true
+	}
+	return nil, false
+}
+
+func (m *marshaler) toAMF0(v reflect.Value) (interface{}, error) {
+	if !v.IsValid() {
+		return amf0.NullType{}, nil
+	}
+	t := v.Type()
+	if t.Kind() == reflect.Ptr && v.IsNil() {
+		return amf0.NullType{}, nil
+	}
+	if isWireType(t, amf0PkgPath) {
+		return v.Interface(), nil
+	}
+	// amf3 values keep their encoding, switched to AMF3
+	if isWireType(t, amf3PkgPath) {
+		return amf0.AvmPlusObjectType{Value: v.Interface()}, nil
+	}
+	if w, ok := flexWrapper(v); ok {
+		return m.wrapAMF0(w)
+	}
+	if t.Kind() != reflect.Interface {
+		if m, ok := implementer(v, marshaler0Type); ok {
+			return m, nil
+		}
+		if m, ok := implementer(v, marshaler3Type); ok {
+			return amf0.AvmPlusObjectType{Value: m}, nil
+		}
+	}
+	if t == timeType {
+		return amf0.DateType{Date: float64(amf3.NewDate(v.Interface().(time.Time)))}, nil
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		return amf0.BooleanType(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return amf0.NumberType(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return amf0.NumberType(v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return amf0.NumberType(v.Float()), nil
+	case reflect.String:
+		if v.Len() > maxStringLength {
+			return amf0.LongStringType(v.String()), nil
+		}
+		return amf0.StringType(v.String()), nil
+	case reflect.Interface:
+		if v.IsNil() {
+			return amf0.NullType{}, nil
+		}
+		return m.toAMF0(v.Elem())
+	case reflect.Ptr:
+		key := seenKey{ptr: v.Pointer(), typ: t}
+		if obj, ok := m.seen[key]; ok {
+			return obj, nil
+		}
+		if v.Elem().Kind() == reflect.Struct && v.Elem().Type() != timeType {
+			return m.structToAMF0(v.Elem(), &key)
+		}
+		return m.toAMF0(v.Elem())
+	case reflect.Struct:
+		return m.structToAMF0(v, nil)
+	case reflect.Map:
+		if v.IsNil() {
+			return amf0.NullType{}, nil
+		}
+		if t.Key().Kind() != reflect.String {
+			return nil, errors.New("amf: unsupported map key type " + t.Key().String())
+		}
+		key := seenKey{ptr: v.Pointer(), typ: t}
+		if obj, ok := m.seen[key]; ok {
+			return obj, nil
+		}
+		obj := new(amf0.ObjectType)
+		*obj = make(amf0.ObjectType)
+		m.seen[key] = obj
+		for _, k := range v.MapKeys() {
+			value, err := m.toAMF0(v.MapIndex(k))
+			if err != nil {
+				return nil, err
+			}
+			(*obj)[amf0.StringType(k.String())] = value
+		}
+		return obj, nil
+	case reflect.Slice:
+		if v.IsNil() {
+			return amf0.NullType{}, nil
+		}
+		key := sliceKey(v)
+		if obj, ok := m.seen[key]; ok {
+			return obj, nil
+		}
+		return m.arrayToAMF0(v, key)
+	case reflect.Array:
+		return m.arrayToAMF0(v, seenKey{})
+	}
+	return nil, errors.New("amf: unsupported type " + t.String())
+}
+
+// sliceKey returns the key of a slice in m.seen. Empty slices cannot refer
+// to themselves and may all share one address, so they get the zero key,
+// under which nothing is recorded.
+func sliceKey(v reflect.Value) seenKey {
+	if v.Len() == 0 {
+		return seenKey{}
+	}
+	return seenKey{ptr: v.Pointer(), len: v.Len(), typ: v.Type()}
+}
+
+// arrayToAMF0 converts a slice or array into a strict array. key, unless
+// zero, records the result for references.
+func (m *marshaler) arrayToAMF0(v reflect.Value, key seenKey) (interface{}, error) {
+	array := make(amf0.StrictArrayType, v.Len())
+	if key != (seenKey{}) {
+		m.seen[key] = &array
+	}
+	for i := 0; i < v.Len(); i++ {
+		value, err := m.toAMF0(v.Index(i))
+		if err != nil {
+			return nil, err
+		}
+		array[i] = value
+	}
+	return &array, nil
+}
+
+// structToAMF0 converts a struct into an object, or a typed object if its
+// type has a class alias. key, if not nil, records the result for references.
+func (m *marshaler) structToAMF0(v reflect.Value, key *seenKey) (interface{}, error) {
+	members := make(map[amf0.StringType]interface{})
+	var obj interface{}
+	if alias, ok := aliasByType(v.Type()); ok {
+		obj = &amf0.TypedObjectType{ClassName: amf0.StringType(alias), Object: members}
+	} else {
+		object := amf0.ObjectType(members)
+		obj = &object
+	}
+	if key != nil {
+		m.seen[*key] = obj
+	}
+	for _, f := range cachedFields(v.Type()) {
+		fv := v.FieldByIndex(f.index)
+		if f.omitEmpty && isEmptyValue(fv) {
+			continue
+		}
+		value, err := m.toAMF0(fv)
+		if err != nil {
+			return nil, err
+		}
+		members[amf0.StringType(f.name)] = value
+	}
+	return obj, nil
+}
+
+func (m *marshaler) toAMF3(v reflect.Value) (interface{}, error) {
+	if !v.IsValid() {
+		return amf3.NullType{}, nil
+	}
+	t := v.Type()
+	if t.Kind() == reflect.Ptr && v.IsNil() {
+		return amf3.NullType{}, nil
+	}
+	if isWireType(t, amf3PkgPath) {
+		return v.Interface(), nil
+	}
+	if w, ok := flexWrapper(v); ok {
+		return m.wrapAMF3(w)
+	}
+	if t.Kind() != reflect.Interface {
+		if m, ok := implementer(v, marshaler3Type); ok {
+			return m, nil
+		}
+	}
+	if t == timeType {
+		date := amf3.NewDate(v.Interface().(time.Time))
+		return &date, nil
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return amf3.TrueType{}, nil
+		}
+		return amf3.FalseType{}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i := v.Int()
+		if i > amf3.MaxInteger || i < amf3.MinInteger {
+			return amf3.DoubleType(i), nil
+		}
+		return amf3.IntegerType(i), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u := v.Uint()
+		if u > amf3.MaxInteger {
+			return amf3.DoubleType(u), nil
+		}
+		return amf3.IntegerType(u), nil
+	case reflect.Float32, reflect.Float64:
+		return amf3.DoubleType(v.Float()), nil
+	case reflect.String:
+		return amf3.StringType(v.String()), nil
+	case reflect.Interface:
+		if v.IsNil() {
+			return amf3.NullType{}, nil
+		}
+		return m.toAMF3(v.Elem())
+	case reflect.Ptr:
+		key := seenKey{ptr: v.Pointer(), typ: t}
+		if obj, ok := m.seen[key]; ok {
+			return obj, nil
+		}
+		if v.Elem().Kind() == reflect.Struct && v.Elem().Type() != timeType {
+			obj := new(amf3.ObjectType)
+			m.seen[key] = obj
+			return obj, m.fillObject3(obj, v.Elem())
+		}
+		return m.toAMF3(v.Elem())
+	case reflect.Struct:
+		obj := new(amf3.ObjectType)
+		return obj, m.fillObject3(obj, v)
+	case reflect.Map:
+		if v.IsNil() {
+			return amf3.NullType{}, nil
+		}
+		key := seenKey{ptr: v.Pointer(), typ: t}
+		if obj, ok := m.seen[key]; ok {
+			return obj, nil
+		}
+		if t.Key().Kind() != reflect.String {
+			dict := new(amf3.DictionaryType)
+			m.seen[key] = dict
+			for _, k := range v.MapKeys() {
+				dk, err := m.toAMF3(k)
+				if err != nil {
+					return nil, err
+				}
+				dv, err := m.toAMF3(v.MapIndex(k))
+				if err != nil {
+					return nil, err
+				}
+				dict.Entries = append(dict.Entries, amf3.DictionaryEntry{Key: dk, Value: dv})
+			}
+			return dict, nil
+		}
+		obj := &amf3.ObjectType{Trait: &amf3.Trait{IsDynamic: true}}
+		obj.Dynamic = make(map[amf3.StringType]interface{})
+		m.seen[key] = obj
+		for _, k := range v.MapKeys() {
+			value, err := m.toAMF3(v.MapIndex(k))
+			if err != nil {
+				return nil, err
+			}
+			obj.Dynamic[amf3.StringType(k.String())] = value
+		}
+		return obj, nil
+	case reflect.Slice:
+		if v.IsNil() {
+			return amf3.NullType{}, nil
+		}
+		if t.Elem().Kind() == reflect.Uint8 {
+			byteArray := amf3.ByteArrayType(append([]byte(nil), v.Bytes()...))
+			return &byteArray, nil
+		}
+		key := sliceKey(v)
+		if obj, ok := m.seen[key]; ok {
+			return obj, nil
+		}
+		return m.arrayToAMF3(v, key)
+	case reflect.Array:
+		return m.arrayToAMF3(v, seenKey{})
+	}
+	return nil, errors.New("amf: unsupported type " + t.String())
+}
+
+// arrayToAMF3 converts a slice or array into an array. key, unless zero,
+// records the result for references.
+func (m *marshaler) arrayToAMF3(v reflect.Value, key seenKey) (interface{}, error) {
+	array := &amf3.ArrayType{Dense: make([]interface{}, v.Len())}
+	if key != (seenKey{}) {
+		m.seen[key] = array
+	}
+	for i := 0; i < v.Len(); i++ {
+		value, err := m.toAMF3(v.Index(i))
+		if err != nil {
+			return nil, err
+		}
+		array.Dense[i] = value
+	}
+	return array, nil
+}
+
+func (m *marshaler) fillObject3(obj *amf3.ObjectType, v reflect.Value) error {
+	obj.Trait = new(amf3.Trait)
+	if alias, ok := aliasByType(v.Type()); ok {
+		obj.Trait.ClassName = amf3.StringType(alias)
+	}
+	for _, f := range cachedFields(v.Type()) {
+		fv := v.FieldByIndex(f.index)
+		if f.omitEmpty && isEmptyValue(fv) {
+			continue
+		}
+		value, err := m.toAMF3(fv)
+		if err != nil {
+			return err
+		}
+		obj.Trait.Attrs = append(obj.Trait.Attrs, amf3.StringType(f.name))
+		obj.Static = append(obj.Static, value)
+	}
+	return nil
+}
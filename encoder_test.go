@@ -0,0 +1,138 @@
+package amf
+
+import (
+	"bytes"
+	"github.com/hongruiqi/amf.go/amf0"
+	"github.com/hongruiqi/amf.go/amf3"
+	"github.com/hongruiqi/amf.go/flex"
+	"testing"
+)
+
+type testUser struct {
+	Name  string `amf:"name"`
+	Age   int    `amf:"age,omitempty"`
+	Skip  string `amf:"-"`
+	inner int
+}
+
+func TestMarshalAMF3Struct(t *testing.T) {
+	got, err := Marshal(testUser{Name: "foo", Age: 3}, AMF3)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	expect := []byte{0x0a, 0x23, 0x01, 0x09, 0x6e, 0x61, 0x6d, 0x65, 0x07, 0x61, 0x67, 0x65, 0x06, 0x07, 0x66, 0x6f, 0x6f, 0x04, 0x03}
+	if !bytes.Equal(expect, got) {
+		t.Errorf("expect %x got %x", expect, got)
+	}
+}
+
+func TestMarshalAMF3OmitEmpty(t *testing.T) {
+	got, err := Marshal(&testUser{Name: "foo"}, AMF3)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	expect := []byte{0x0a, 0x13, 0x01, 0x09, 0x6e, 0x61, 0x6d, 0x65, 0x06, 0x07, 0x66, 0x6f, 0x6f}
+	if !bytes.Equal(expect, got) {
+		t.Errorf("expect %x got %x", expect, got)
+	}
+}
+
+func TestMarshalAMF3Slice(t *testing.T) {
+	got, err := Marshal([]interface{}{1, "foo", true, nil, 1.5, 1 << 30}, AMF3)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	expect := []byte{0x09, 0x0d, 0x01, 0x04, 0x01, 0x06, 0x07, 0x66, 0x6f, 0x6f, 0x03, 0x01,
+		0x05, 0x3f, 0xf8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x05, 0x41, 0xd0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+	if !bytes.Equal(expect, got) {
+		t.Errorf("expect %x got %x", expect, got)
+	}
+}
+
+func TestMarshalAMF0Struct(t *testing.T) {
+	got, err := Marshal(testUser{Name: "foo"}, AMF0)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	expect := []byte{0x03, 0x00, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x02, 0x00, 0x03, 0x66, 0x6f, 0x6f, 0x00, 0x00, 0x09}
+	if !bytes.Equal(expect, got) {
+		t.Errorf("expect %x got %x", expect, got)
+	}
+}
+
+func TestMarshalAMF0WithAMF3Values(t *testing.T) {
+	msg := new(flex.AcknowledgeMessage)
+	got, err := Marshal([]interface{}{amf3.StringType("foo"), msg}, AMF0)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	// each switches to AMF3 rather than being taken apart as an AMF0 value
+	expect := []byte{0x0a, 0x00, 0x00, 0x00, 0x02, 0x11, 0x06, 0x07, 0x66, 0x6f, 0x6f, 0x11}
+	if !bytes.HasPrefix(got, expect) {
+		t.Errorf("expect %x... got %x", expect, got)
+	}
+}
+
+func TestMarshalPointerReference(t *testing.T) {
+	type node struct {
+		Next *node
+	}
+	n := new(node)
+	n.Next = n
+	got, err := Marshal(n, AMF3)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	expect := []byte{0x0a, 0x13, 0x01, 0x09, 0x4e, 0x65, 0x78, 0x74, 0x0a, 0x00}
+	if !bytes.Equal(expect, got) {
+		t.Errorf("expect %x got %x", expect, got)
+	}
+}
+
+func TestMarshalSliceReference(t *testing.T) {
+	// an array holding itself, which Unmarshal keeps as a slice holding itself
+	var v interface{}
+	err := Unmarshal([]byte{0x09, 0x03, 0x01, 0x09, 0x00}, &v, AMF3)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	tests := []struct {
+		version int
+		expect  []byte
+	}{
+		{AMF0, []byte{0x0a, 0x00, 0x00, 0x00, 0x01, 0x07, 0x00, 0x00}},
+		{AMF3, []byte{0x09, 0x03, 0x01, 0x09, 0x00}},
+	}
+	for _, test := range tests {
+		got, err := Marshal(v, test.version)
+		if err != nil {
+			t.Fatalf("version %d: %s", test.version, err)
+		}
+		if !bytes.Equal(test.expect, got) {
+			t.Errorf("version %d: expect %x got %x", test.version, test.expect, got)
+		}
+	}
+}
+
+func TestMarshalNilWirePointers(t *testing.T) {
+	v := struct {
+		A *amf3.ArrayType  `amf:"a"`
+		O *amf3.ObjectType `amf:"o"`
+		N *amf0.ObjectType `amf:"n"`
+	}{}
+	for _, version := range []int{AMF0, AMF3} {
+		data, err := Marshal(v, version)
+		if err != nil {
+			t.Fatalf("version %d: %s", version, err)
+		}
+		var got map[string]interface{}
+		err = Unmarshal(data, &got, version)
+		if err != nil {
+			t.Fatalf("version %d: %s", version, err)
+		}
+		if len(got) != 3 || got["a"] != nil || got["o"] != nil || got["n"] != nil {
+			t.Errorf("version %d: expect null members got %v", version, got)
+		}
+	}
+}
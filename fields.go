@@ -0,0 +1,112 @@
+package amf
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// field describes a struct field mapped to an AMF member.
+type field struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+var (
+	fieldCacheMu sync.RWMutex
+	fieldCache   = make(map[reflect.Type][]field)
+)
+
+// cachedFields returns the AMF members of struct type t, honouring
+// `amf:"name,omitempty"` tags and flattening embedded structs.
+func cachedFields(t reflect.Type) []field {
+	fieldCacheMu.RLock()
+	fields, ok := fieldCache[t]
+	fieldCacheMu.RUnlock()
+	if ok {
+		return fields
+	}
+	fields = typeFields(t, nil)
+	fieldCacheMu.Lock()
+	fieldCache[t] = fields
+	fieldCacheMu.Unlock()
+	return fields
+}
+
+func typeFields(t reflect.Type, index []int) []field {
+	var fields []field
+	var embedded [][]field
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("amf")
+		if tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if idx := strings.Index(tag, ","); idx >= 0 {
+			name, opts = tag[:idx], tag[idx+1:]
+		}
+		fieldIndex := make([]int, len(index)+1)
+		copy(fieldIndex, index)
+		fieldIndex[len(index)] = i
+		if sf.Anonymous && name == "" && sf.Type.Kind() == reflect.Struct {
+			embedded = append(embedded, typeFields(sf.Type, fieldIndex))
+			continue
+		}
+		if sf.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		fields = append(fields, field{name: name, index: fieldIndex, omitEmpty: opts == "omitempty"})
+	}
+	// fields of the outer struct hide those promoted from embedded ones
+	for _, efields := range embedded {
+	next:
+		for _, ef := range efields {
+			for _, f := range fields {
+				if f.name == ef.name {
+					continue next
+				}
+			}
+			fields = append(fields, ef)
+		}
+	}
+	return fields
+}
+
+// fieldByName finds the field named name, falling back to a
+// case-insensitive match.
+func fieldByName(fields []field, name string) *field {
+	for i := range fields {
+		if fields[i].name == name {
+			return &fields[i]
+		}
+	}
+	for i := range fields {
+		if strings.EqualFold(fields[i].name, name) {
+			return &fields[i]
+		}
+	}
+	return nil
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
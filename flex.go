@@ -0,0 +1,122 @@
+package amf
+
+import (
+	"context"
+	"github.com/hongruiqi/amf.go/amf3"
+	"github.com/hongruiqi/amf.go/flex"
+	"reflect"
+	"time"
+)
+
+var flexWrapperTypes = map[reflect.Type]bool{
+	reflect.TypeOf(flex.ArrayCollection{}): true,
+	reflect.TypeOf(flex.ArrayList{}):       true,
+	reflect.TypeOf(flex.ObjectProxy{}):     true,
+}
+
+// flexWrapper returns v, or what v points to, if it is a Flex collection or
+// proxy. Their MarshalAMF3 writes the wrapped value as is, so Marshal
+// converts it first.
+func flexWrapper(v reflect.Value) (reflect.Value, bool) {
+	if v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	return v, flexWrapperTypes[v.Type()]
+}
+
+// wrapAMF3 returns a copy of the wrapper w holding its value converted for
+// AMF3.
+func (m *marshaler) wrapAMF3(w reflect.Value) (interface{}, error) {
+	value, err := m.toAMF3(w.Field(0))
+	if err != nil {
+		return nil, err
+	}
+	c := reflect.New(w.Type()).Elem()
+	c.Field(0).Set(reflect.ValueOf(value))
+	return c.Interface(), nil
+}
+
+// wrapAMF0 returns the value of the wrapper w, as AMF0 has no externalizable
+// objects.
+func (m *marshaler) wrapAMF0(w reflect.Value) (interface{}, error) {
+	return m.toAMF0(w.Field(0))
+}
+
+// serveFlex answers a message of a Flex client, which targets "null" with
+// the message as the only argument. It returns an acknowledgement, or an
+// error message and false.
+func (g *Gateway) serveFlex(ctx context.Context, body interface{}) (interface{}, bool) {
+	args, err := callArguments(body)
+	if err == nil && len(args) != 1 {
+		err = newFault("Server.Processing", "expected a single message")
+	}
+	if err != nil {
+		return flexError(nil, err), false
+	}
+	u := &unmarshaler{seen: make(map[unmarshalKey]reflect.Value)}
+	switch class := className(args[0]); class {
+	case flex.RemotingMessageClass:
+		req := new(flex.RemotingMessage)
+		err = u.assign(reflect.ValueOf(req).Elem(), args[0])
+		if err != nil {
+			return flexError(nil, err), false
+		}
+		result, err := g.invoke(ctx, req.Destination, req.Operation, req.Body)
+		if err == nil {
+			result, err = marshalBody(result, AMF3)
+		}
+		if err != nil {
+			return flexError(&req.AbstractMessage, err), false
+		}
+		ack := newAcknowledge(&req.AbstractMessage)
+		ack.Body = result
+		return ack, true
+	case flex.CommandMessageClass, flex.CommandMessageExtClass:
+		req := new(flex.CommandMessage)
+		err = u.assign(reflect.ValueOf(req).Elem(), args[0])
+		if err != nil {
+			return flexError(nil, err), false
+		}
+		switch req.Operation {
+		case flex.ClientPingOperation, flex.LoginOperation, flex.LogoutOperation, flex.DisconnectOperation:
+		default:
+			return flexError(&req.AbstractMessage, newFault("Server.Processing", "unsupported command operation")), false
+		}
+		ack := newAcknowledge(&req.AbstractMessage)
+		// the client learns its id from the answer to its first ping
+		id, _ := req.Headers[flex.FlexClientIDHeader].(amf3.StringType)
+		if id == "" || id == "nil" {
+			id = amf3.StringType(flex.NewUID())
+		}
+		ack.Headers = map[string]interface{}{flex.FlexClientIDHeader: id}
+		if class == flex.CommandMessageExtClass {
+			return ack.SmallMessage(), true
+		}
+		return ack, true
+	default:
+		return flexError(nil, newFault("Server.Processing", "unsupported message class "+class)), false
+	}
+}
+
+func newAcknowledge(req *flex.AbstractMessage) *flex.AcknowledgeMessage {
+	ack := new(flex.AcknowledgeMessage)
+	ack.MessageID = flex.NewUID()
+	ack.ClientID = req.ClientID
+	ack.CorrelationID = req.MessageID
+	ack.Timestamp = float64(amf3.NewDate(time.Now()))
+	return ack
+}
+
+// flexError answers req, if it could be decoded, with err.
+func flexError(req *flex.AbstractMessage, err error) *flex.ErrorMessage {
+	if req == nil {
+		req = new(flex.AbstractMessage)
+	}
+	fault := errorFault(err)
+	return &flex.ErrorMessage{
+		AcknowledgeMessage: *newAcknowledge(req),
+		FaultCode:          fault.Code,
+		FaultString:        fault.Description,
+		FaultDetail:        fault.Details,
+	}
+}
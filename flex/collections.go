@@ -0,0 +1,70 @@
+package flex
+
+import (
+	"github.com/hongruiqi/amf.go/amf3"
+)
+
+// Class names of the collections, which are externalizable.
+const (
+	ArrayCollectionClass = "flex.messaging.io.ArrayCollection"
+	ArrayListClass       = "flex.messaging.io.ArrayList"
+	ObjectProxyClass     = "flex.messaging.io.ObjectProxy"
+)
+
+// A collection or proxy decodes as an amf3.ObjectType holding what it wraps
+// in External: an *amf3.ArrayType, or the object of a proxy. The wrapped
+// value comes out in its place with amf3.Decoder.UnwrapExternalizable, and
+// as a plain slice or map with amf3.Decoder.UsePlainTypes.
+func init() {
+	for _, class := range []amf3.StringType{ArrayCollectionClass, ArrayListClass, ObjectProxyClass} {
+		amf3.RegisterExternalizable(class, readWrapped, writeWrapped)
+	}
+}
+
+func readWrapped(dec *amf3.Decoder) (interface{}, error) {
+	return dec.Decode()
+}
+
+func writeWrapped(enc *amf3.Encoder, v interface{}) error {
+	return enc.EncodeValue(v)
+}
+
+func encodeWrapped(enc *amf3.Encoder, class string, v interface{}) error {
+	return enc.EncodeValue(&amf3.ObjectType{
+		Trait:    &amf3.Trait{ClassName: amf3.StringType(class), IsExternalizable: true},
+		External: v,
+	})
+}
+
+// ArrayCollection encodes Source, an array, as an ArrayCollection, which
+// Flex list controls such as DataGrid expect. Source may be any value an
+// amf3.Encoder takes, or, when encoded by amf.Marshal, any slice.
+type ArrayCollection struct {
+	Source interface{}
+}
+
+// MarshalAMF3 encodes c.
+func (c ArrayCollection) MarshalAMF3(enc *amf3.Encoder) error {
+	return encodeWrapped(enc, ArrayCollectionClass, c.Source)
+}
+
+// ArrayList encodes Source, an array, as an ArrayList.
+type ArrayList struct {
+	Source interface{}
+}
+
+// MarshalAMF3 encodes l.
+func (l ArrayList) MarshalAMF3(enc *amf3.Encoder) error {
+	return encodeWrapped(enc, ArrayListClass, l.Source)
+}
+
+// ObjectProxy encodes Object as an ObjectProxy, which lets Flex bind to
+// changes of its members.
+type ObjectProxy struct {
+	Object interface{}
+}
+
+// MarshalAMF3 encodes p.
+func (p ObjectProxy) MarshalAMF3(enc *amf3.Encoder) error {
+	return encodeWrapped(enc, ObjectProxyClass, p.Object)
+}
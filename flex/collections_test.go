@@ -0,0 +1,85 @@
+package flex
+
+import (
+	"bytes"
+	"github.com/hongruiqi/amf.go/amf3"
+	"reflect"
+	"testing"
+)
+
+func TestEncodeArrayCollection(t *testing.T) {
+	buf := new(bytes.Buffer)
+	err := amf3.NewEncoder(buf).Encode(ArrayCollection{Source: &amf3.ArrayType{Dense: []interface{}{amf3.IntegerType(1)}}})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	expect := append([]byte{0x0a, 0x07, 0x43}, ArrayCollectionClass...)
+	expect = append(expect, 0x09, 0x03, 0x01, 0x04, 0x01)
+	if !bytes.Equal(expect, buf.Bytes()) {
+		t.Errorf("expect %x got %x", expect, buf.Bytes())
+	}
+}
+
+func roundTrip(t *testing.T, v interface{}) (data []byte, decoded interface{}) {
+	buf := new(bytes.Buffer)
+	err := amf3.NewEncoder(buf).Encode(v)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	data = buf.Bytes()
+	decoded, err = amf3.NewDecoder(bytes.NewReader(data)).Decode()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	return data, decoded
+}
+
+func TestDecodeCollections(t *testing.T) {
+	array := &amf3.ArrayType{Dense: []interface{}{amf3.StringType("a"), amf3.StringType("b")}}
+	object := &amf3.ObjectType{Trait: &amf3.Trait{IsDynamic: true}, Dynamic: map[amf3.StringType]interface{}{"k": amf3.IntegerType(1)}}
+	tests := []struct {
+		value   interface{}
+		class   string
+		wrapped interface{}
+	}{
+		{ArrayCollection{Source: array}, ArrayCollectionClass, array},
+		{ArrayList{Source: array}, ArrayListClass, array},
+		{ObjectProxy{Object: object}, ObjectProxyClass, object},
+	}
+	for _, test := range tests {
+		_, expect := roundTrip(t, test.wrapped)
+		data, got := roundTrip(t, test.value)
+		obj, ok := got.(*amf3.ObjectType)
+		if !ok || string(obj.Trait.ClassName) != test.class || !reflect.DeepEqual(expect, obj.External) {
+			t.Errorf("%s: expect %#v wrapped got %#v", test.class, expect, got)
+		}
+
+		dec := amf3.NewDecoder(bytes.NewReader(data))
+		dec.UnwrapExternalizable()
+		got, err := dec.Decode()
+		if err != nil {
+			t.Fatalf("%s: %s", test.class, err)
+		}
+		if !reflect.DeepEqual(expect, got) {
+			t.Errorf("%s: unwrapped: expect %#v got %#v", test.class, expect, got)
+		}
+	}
+}
+
+func TestDecodeCollectionPlain(t *testing.T) {
+	buf := new(bytes.Buffer)
+	err := amf3.NewEncoder(buf).Encode(ArrayCollection{Source: &amf3.ArrayType{Dense: []interface{}{amf3.StringType("a")}}})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	dec := amf3.NewDecoder(buf)
+	dec.UsePlainTypes()
+	dec.UnwrapExternalizable()
+	got, err := dec.Decode()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if expect := []interface{}{"a"}; !reflect.DeepEqual(expect, got) {
+		t.Errorf("expect %#v got %#v", expect, got)
+	}
+}
@@ -0,0 +1,405 @@
+// Package flex implements the messages and collections BlazeDS and Flex
+// clients exchange in AMF3, such as the RemotingMessage a RemoteObject
+// sends and the AcknowledgeMessage or ErrorMessage it expects back.
+//
+// The types decode themselves from an amf3.Decoder and encode themselves
+// through an amf3.Encoder, in their full form or, for the messages that
+// have one, in the externalizable small form (DSA, DSK and DSC).
+package flex
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"github.com/hongruiqi/amf.go/amf3"
+)
+
+// Class names of the messages in their full form.
+const (
+	AsyncMessageClass       = "flex.messaging.messages.AsyncMessage"
+	AcknowledgeMessageClass = "flex.messaging.messages.AcknowledgeMessage"
+	ErrorMessageClass       = "flex.messaging.messages.ErrorMessage"
+	RemotingMessageClass    = "flex.messaging.messages.RemotingMessage"
+	CommandMessageClass     = "flex.messaging.messages.CommandMessage"
+)
+
+// Operations of a CommandMessage.
+const (
+	SubscribeOperation              = 0
+	UnsubscribeOperation            = 1
+	PollOperation                   = 2
+	ClientSyncOperation             = 4
+	ClientPingOperation             = 5
+	ClusterRequestOperation         = 7
+	LoginOperation                  = 8
+	LogoutOperation                 = 9
+	SubscriptionInvalidateOperation = 10
+	MultiSubscribeOperation         = 11
+	DisconnectOperation             = 12
+	TriggerConnectOperation         = 13
+	UnknownOperation                = 10000
+)
+
+// Names of message headers.
+const (
+	FlexClientIDHeader      = "DSId"
+	MessagingVersionHeader  = "DSMessagingVersion"
+	EndpointHeader          = "DSEndpoint"
+	RemoteCredentialsHeader = "DSRemoteCredentials"
+	RequestTimeoutHeader    = "DSRequestTimeout"
+)
+
+// AbstractMessage holds the members common to all messages. Body and the
+// header values hold amf3 wire types when decoded, and anything an
+// amf3.Encoder accepts when encoded.
+type AbstractMessage struct {
+	Body        interface{}
+	ClientID    string
+	Destination string
+	Headers     map[string]interface{}
+	MessageID   string
+	Timestamp   float64 // milliseconds since the epoch
+	TimeToLive  float64 // milliseconds
+}
+
+// AsyncMessage is a message published to or received from a destination.
+type AsyncMessage struct {
+	AbstractMessage
+	CorrelationID string // MessageID of the message answered
+}
+
+// AcknowledgeMessage answers a message that succeeded, carrying its result
+// in Body.
+type AcknowledgeMessage struct {
+	AsyncMessage
+}
+
+// ErrorMessage answers a message that failed.
+type ErrorMessage struct {
+	AcknowledgeMessage
+	FaultCode    string
+	FaultString  string
+	FaultDetail  string
+	RootCause    interface{}
+	ExtendedData map[string]interface{}
+}
+
+func (m *ErrorMessage) Error() string {
+	return "flex: " + m.FaultCode + ": " + m.FaultString
+}
+
+// RemotingMessage calls Operation on the service at Destination, with the
+// arguments in Body as an array.
+type RemotingMessage struct {
+	AbstractMessage
+	Operation string
+	Source    string
+}
+
+// CommandMessage asks the server to ping, log in, subscribe and the like.
+type CommandMessage struct {
+	AsyncMessage
+	Operation int
+}
+
+// members maps the names of the members of a full form message to their
+// values.
+type members map[amf3.StringType]interface{}
+
+// decodeMessage decodes the next value, which must be a message of one of
+// the given classes. It returns the members of a message in its full form,
+// or the message read from its small form.
+func decodeMessage(dec *amf3.Decoder, classes ...string) (members, interface{}, error) {
+	v, err := dec.DecodeWire()
+	if err != nil {
+		return nil, nil, err
+	}
+	switch v.(type) {
+	case *AsyncMessage, *AcknowledgeMessage, *CommandMessage:
+		// a small form unwrapped by the decoder
+		return nil, v, nil
+	}
+	obj, ok := v.(*amf3.ObjectType)
+	if !ok || obj.Trait == nil {
+		return nil, nil, errors.New("flex: message is not a typed object")
+	}
+	for _, class := range classes {
+		if string(obj.Trait.ClassName) != class {
+			continue
+		}
+		if obj.Trait.IsExternalizable {
+			return nil, obj.External, nil
+		}
+		return objectMembers(obj), nil, nil
+	}
+	return nil, nil, errors.New("flex: unexpected message class " + string(obj.Trait.ClassName))
+}
+
+// unexpectedMessage reports a small form message of the wrong type.
+func unexpectedMessage(v interface{}) error {
+	return fmt.Errorf("flex: unexpected message %T", v)
+}
+
+func objectMembers(obj *amf3.ObjectType) members {
+	ms := make(members, len(obj.Static)+len(obj.Dynamic))
+	for name, value := range obj.Dynamic {
+		ms[name] = value
+	}
+	if obj.Trait != nil && len(obj.Trait.Attrs) == len(obj.Static) {
+		for i, name := range obj.Trait.Attrs {
+			ms[name] = obj.Static[i]
+		}
+	}
+	return ms
+}
+
+// encodeObject encodes a full form message of class with members in the
+// order of attrs.
+func encodeObject(enc *amf3.Encoder, class string, attrs []amf3.StringType, values []interface{}) error {
+	return enc.EncodeValue(&amf3.ObjectType{
+		Trait:  &amf3.Trait{ClassName: amf3.StringType(class), Attrs: attrs},
+		Static: values,
+	})
+}
+
+func (m *AbstractMessage) readMembers(ms members) {
+	m.Body = ms["body"]
+	if isNull(m.Body) {
+		m.Body = nil
+	}
+	m.ClientID = toString(ms["clientId"])
+	m.Destination = toString(ms["destination"])
+	m.Headers = toMap(ms["headers"])
+	m.MessageID = toString(ms["messageId"])
+	m.Timestamp = toNumber(ms["timestamp"])
+	m.TimeToLive = toNumber(ms["timeToLive"])
+}
+
+func (m *AbstractMessage) appendMembers(attrs []amf3.StringType, values []interface{}) ([]amf3.StringType, []interface{}) {
+	headers := m.Headers
+	if headers == nil {
+		headers = map[string]interface{}{}
+	}
+	attrs = append(attrs, "body", "clientId", "destination", "headers", "messageId", "timestamp", "timeToLive")
+	values = append(values, m.Body, nullable(m.ClientID), nullable(m.Destination), headers,
+		nullable(m.MessageID), amf3.DoubleType(m.Timestamp), amf3.DoubleType(m.TimeToLive))
+	return attrs, values
+}
+
+func (m *AsyncMessage) readMembers(ms members) {
+	m.AbstractMessage.readMembers(ms)
+	m.CorrelationID = toString(ms["correlationId"])
+}
+
+func (m *AsyncMessage) appendMembers(attrs []amf3.StringType, values []interface{}) ([]amf3.StringType, []interface{}) {
+	attrs, values = m.AbstractMessage.appendMembers(attrs, values)
+	return append(attrs, "correlationId"), append(values, nullable(m.CorrelationID))
+}
+
+func (m *ErrorMessage) readMembers(ms members) {
+	m.AsyncMessage.readMembers(ms)
+	m.FaultCode = toString(ms["faultCode"])
+	m.FaultString = toString(ms["faultString"])
+	m.FaultDetail = toString(ms["faultDetail"])
+	m.RootCause = ms["rootCause"]
+	if isNull(m.RootCause) {
+		m.RootCause = nil
+	}
+	m.ExtendedData = toMap(ms["extendedData"])
+}
+
+func (m *ErrorMessage) appendMembers(attrs []amf3.StringType, values []interface{}) ([]amf3.StringType, []interface{}) {
+	attrs, values = m.AsyncMessage.appendMembers(attrs, values)
+	var extendedData interface{} = amf3.NullType{}
+	if m.ExtendedData != nil {
+		extendedData = m.ExtendedData
+	}
+	attrs = append(attrs, "faultCode", "faultString", "faultDetail", "rootCause", "extendedData")
+	values = append(values, nullable(m.FaultCode), nullable(m.FaultString), nullable(m.FaultDetail),
+		m.RootCause, extendedData)
+	return attrs, values
+}
+
+func (m *RemotingMessage) readMembers(ms members) {
+	m.AbstractMessage.readMembers(ms)
+	m.Operation = toString(ms["operation"])
+	m.Source = toString(ms["source"])
+}
+
+func (m *RemotingMessage) appendMembers(attrs []amf3.StringType, values []interface{}) ([]amf3.StringType, []interface{}) {
+	attrs, values = m.AbstractMessage.appendMembers(attrs, values)
+	return append(attrs, "operation", "source"), append(values, nullable(m.Operation), nullable(m.Source))
+}
+
+func (m *CommandMessage) readMembers(ms members) {
+	m.AsyncMessage.readMembers(ms)
+	m.Operation = int(toNumber(ms["operation"]))
+}
+
+func (m *CommandMessage) appendMembers(attrs []amf3.StringType, values []interface{}) ([]amf3.StringType, []interface{}) {
+	attrs, values = m.AsyncMessage.appendMembers(attrs, values)
+	return append(attrs, "operation"), append(values, amf3.IntegerType(m.Operation))
+}
+
+// UnmarshalAMF3 decodes an AsyncMessage in its full or DSA form.
+func (m *AsyncMessage) UnmarshalAMF3(dec *amf3.Decoder) error {
+	ms, ext, err := decodeMessage(dec, AsyncMessageClass, AsyncMessageExtClass)
+	if err != nil {
+		return err
+	}
+	if ext != nil {
+		msg, ok := ext.(*AsyncMessage)
+		if !ok {
+			return unexpectedMessage(ext)
+		}
+		*m = *msg
+		return nil
+	}
+	m.readMembers(ms)
+	return nil
+}
+
+// MarshalAMF3 encodes m in its full form.
+func (m *AsyncMessage) MarshalAMF3(enc *amf3.Encoder) error {
+	attrs, values := m.appendMembers(nil, nil)
+	return encodeObject(enc, AsyncMessageClass, attrs, values)
+}
+
+// UnmarshalAMF3 decodes an AcknowledgeMessage in its full or DSK form.
+func (m *AcknowledgeMessage) UnmarshalAMF3(dec *amf3.Decoder) error {
+	ms, ext, err := decodeMessage(dec, AcknowledgeMessageClass, AcknowledgeMessageExtClass)
+	if err != nil {
+		return err
+	}
+	if ext != nil {
+		msg, ok := ext.(*AcknowledgeMessage)
+		if !ok {
+			return unexpectedMessage(ext)
+		}
+		*m = *msg
+		return nil
+	}
+	m.readMembers(ms)
+	return nil
+}
+
+// MarshalAMF3 encodes m in its full form.
+func (m *AcknowledgeMessage) MarshalAMF3(enc *amf3.Encoder) error {
+	attrs, values := m.appendMembers(nil, nil)
+	return encodeObject(enc, AcknowledgeMessageClass, attrs, values)
+}
+
+// UnmarshalAMF3 decodes an ErrorMessage.
+func (m *ErrorMessage) UnmarshalAMF3(dec *amf3.Decoder) error {
+	ms, ext, err := decodeMessage(dec, ErrorMessageClass)
+	if err != nil {
+		return err
+	}
+	if ext != nil {
+		return unexpectedMessage(ext)
+	}
+	m.readMembers(ms)
+	return nil
+}
+
+// MarshalAMF3 encodes m.
+func (m *ErrorMessage) MarshalAMF3(enc *amf3.Encoder) error {
+	attrs, values := m.appendMembers(nil, nil)
+	return encodeObject(enc, ErrorMessageClass, attrs, values)
+}
+
+// UnmarshalAMF3 decodes a RemotingMessage.
+func (m *RemotingMessage) UnmarshalAMF3(dec *amf3.Decoder) error {
+	ms, ext, err := decodeMessage(dec, RemotingMessageClass)
+	if err != nil {
+		return err
+	}
+	if ext != nil {
+		return unexpectedMessage(ext)
+	}
+	m.readMembers(ms)
+	return nil
+}
+
+// MarshalAMF3 encodes m.
+func (m *RemotingMessage) MarshalAMF3(enc *amf3.Encoder) error {
+	attrs, values := m.appendMembers(nil, nil)
+	return encodeObject(enc, RemotingMessageClass, attrs, values)
+}
+
+// UnmarshalAMF3 decodes a CommandMessage in its full or DSC form.
+func (m *CommandMessage) UnmarshalAMF3(dec *amf3.Decoder) error {
+	ms, ext, err := decodeMessage(dec, CommandMessageClass, CommandMessageExtClass)
+	if err != nil {
+		return err
+	}
+	if ext != nil {
+		msg, ok := ext.(*CommandMessage)
+		if !ok {
+			return unexpectedMessage(ext)
+		}
+		*m = *msg
+		return nil
+	}
+	m.readMembers(ms)
+	return nil
+}
+
+// MarshalAMF3 encodes m in its full form.
+func (m *CommandMessage) MarshalAMF3(enc *amf3.Encoder) error {
+	attrs, values := m.appendMembers(nil, nil)
+	return encodeObject(enc, CommandMessageClass, attrs, values)
+}
+
+// NewUID returns a random id for a message or a client, in the format of
+// mx.utils.UIDUtil.
+func NewUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return formatUID(b[:])
+}
+
+func isNull(v interface{}) bool {
+	switch v.(type) {
+	case nil, amf3.NullType, amf3.UndefinedType:
+		return true
+	}
+	return false
+}
+
+// nullable returns s, or null for an empty s as Flex sends unset strings.
+func nullable(s string) interface{} {
+	if s == "" {
+		return amf3.NullType{}
+	}
+	return amf3.StringType(s)
+}
+
+func toString(v interface{}) string {
+	s, _ := v.(amf3.StringType)
+	return string(s)
+}
+
+func toNumber(v interface{}) float64 {
+	switch n := v.(type) {
+	case amf3.IntegerType:
+		return float64(n)
+	case amf3.DoubleType:
+		return float64(n)
+	}
+	return 0
+}
+
+// toMap returns the members of an anonymous object, such as the headers of
+// a message.
+func toMap(v interface{}) map[string]interface{} {
+	obj, ok := v.(*amf3.ObjectType)
+	if !ok {
+		return nil
+	}
+	m := make(map[string]interface{}, len(obj.Static)+len(obj.Dynamic))
+	for name, value := range objectMembers(obj) {
+		m[string(name)] = value
+	}
+	return m
+}
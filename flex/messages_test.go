@@ -0,0 +1,165 @@
+package flex
+
+import (
+	"bytes"
+	"github.com/hongruiqi/amf.go/amf3"
+	"reflect"
+	"testing"
+)
+
+var testAbstractMessage = AbstractMessage{
+	Body:        amf3.StringType("foo"),
+	ClientID:    "client",
+	Destination: "dest",
+	Headers:     map[string]interface{}{FlexClientIDHeader: amf3.StringType("id")},
+	MessageID:   "message",
+	Timestamp:   1e12,
+	TimeToLive:  1000,
+}
+
+func TestMessageRoundTrip(t *testing.T) {
+	async := AsyncMessage{AbstractMessage: testAbstractMessage, CorrelationID: "request"}
+	ack := &AcknowledgeMessage{AsyncMessage: async}
+	command := &CommandMessage{AsyncMessage: async, Operation: ClientPingOperation}
+	tests := []struct {
+		value  interface{} // what is encoded
+		expect interface{} // what is decoded into a new value of its type
+	}{
+		{&async, &async},
+		{async.SmallMessage(), &async},
+		{ack, ack},
+		{ack.SmallMessage(), ack},
+		{command, command},
+		{command.SmallMessage(), command},
+		{&ErrorMessage{AcknowledgeMessage: *ack, FaultCode: "Server.Call.Failed", FaultString: "broken",
+			ExtendedData: map[string]interface{}{"retry": amf3.FalseType{}}}, nil},
+		{&RemotingMessage{AbstractMessage: testAbstractMessage, Operation: "echo", Source: "Echo"}, nil},
+	}
+	for _, plain := range []bool{false, true} {
+		for _, test := range tests {
+			expect := test.expect
+			if expect == nil {
+				expect = test.value
+			}
+			buf := new(bytes.Buffer)
+			err := amf3.NewEncoder(buf).Encode(test.value)
+			if err != nil {
+				t.Fatalf("%T: %s", expect, err)
+			}
+			dec := amf3.NewDecoder(buf)
+			if plain {
+				// messages come out the same, their members as wire types
+				dec.UsePlainTypes()
+			}
+			got := reflect.New(reflect.TypeOf(expect).Elem()).Interface().(amf3.Unmarshaler)
+			err = got.UnmarshalAMF3(dec)
+			if err != nil {
+				t.Fatalf("%T, plain %v: %s", expect, plain, err)
+			}
+			if !reflect.DeepEqual(expect, got) {
+				t.Errorf("plain %v: expect %+v got %+v", plain, expect, got)
+			}
+		}
+	}
+}
+
+func TestDecodePlainSmallMessage(t *testing.T) {
+	expect := &AcknowledgeMessage{AsyncMessage: AsyncMessage{AbstractMessage: testAbstractMessage, CorrelationID: "request"}}
+	buf := new(bytes.Buffer)
+	err := amf3.NewEncoder(buf).Encode(expect.SmallMessage())
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	dec := amf3.NewDecoder(buf)
+	dec.UsePlainTypes()
+	got, err := dec.Decode()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if !reflect.DeepEqual(expect, got) {
+		t.Errorf("expect %+v got %+v", expect, got)
+	}
+}
+
+func TestEncodeSmallMessage(t *testing.T) {
+	ack := new(AcknowledgeMessage)
+	ack.Body = amf3.IntegerType(1)
+	ack.CorrelationID = "c"
+	buf := new(bytes.Buffer)
+	err := amf3.NewEncoder(buf).Encode(ack.SmallMessage())
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	expect := []byte{0x0a, 0x07, 0x07, 'D', 'S', 'K',
+		0x01, 0x04, 0x01, // body
+		0x01, 0x06, 0x03, 'c', // correlation id
+		0x00}
+	if !bytes.Equal(expect, buf.Bytes()) {
+		t.Errorf("expect %x got %x", expect, buf.Bytes())
+	}
+}
+
+func TestDecodeSmallMessage(t *testing.T) {
+	uid := []byte{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef, 0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef}
+	data := []byte{0x0a, 0x07, 0x07, 'D', 'S', 'C'}
+	// headers, and another flag byte for the client and message ids as
+	// bytes and a member unknown to us
+	data = append(data, 0x88, 0x07)
+	data = append(data, 0x0a, 0x0b, 0x01, 0x09, 'D', 'S', 'I', 'd', 0x06, 0x05, 'a', 'b', 0x01)
+	data = append(data, 0x0c, 0x21)
+	data = append(data, uid...)
+	data = append(data, 0x0c, 0x21)
+	data = append(data, uid...)
+	data = append(data, 0x04, 0x07, // the unknown member
+		0x01, 0x06, 0x05, 'c', '1', // correlation id
+		0x01, 0x04, 0x05) // operation
+	v, err := amf3.NewDecoder(bytes.NewReader(data)).Decode()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	obj, ok := v.(*amf3.ObjectType)
+	if !ok {
+		t.Fatalf("expect an object got %T", v)
+	}
+	const id = "01234567-89AB-CDEF-0123-456789ABCDEF"
+	expect := &CommandMessage{Operation: ClientPingOperation}
+	expect.ClientID = id
+	expect.MessageID = id
+	expect.Headers = map[string]interface{}{FlexClientIDHeader: amf3.StringType("ab")}
+	expect.CorrelationID = "c1"
+	if !reflect.DeepEqual(expect, obj.External) {
+		t.Errorf("expect %+v got %+v", expect, obj.External)
+	}
+}
+
+func TestUnmarshalWrongClass(t *testing.T) {
+	buf := new(bytes.Buffer)
+	err := amf3.NewEncoder(buf).Encode(new(RemotingMessage))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	err = new(CommandMessage).UnmarshalAMF3(amf3.NewDecoder(buf))
+	if err == nil {
+		t.Errorf("expect error decoding a RemotingMessage into a CommandMessage")
+	}
+}
+
+func TestUnmarshalUnwrappedSmallMessage(t *testing.T) {
+	expect := &CommandMessage{Operation: ClientPingOperation}
+	expect.MessageID = "ping"
+	buf := new(bytes.Buffer)
+	err := amf3.NewEncoder(buf).Encode(expect.SmallMessage())
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	dec := amf3.NewDecoder(buf)
+	dec.UnwrapExternalizable()
+	got := new(CommandMessage)
+	err = got.UnmarshalAMF3(dec)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if !reflect.DeepEqual(expect, got) {
+		t.Errorf("expect %+v got %+v", expect, got)
+	}
+}
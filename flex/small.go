@@ -0,0 +1,378 @@
+package flex
+
+import (
+	"errors"
+	"fmt"
+	"github.com/hongruiqi/amf.go/amf3"
+)
+
+// Class names of the messages in their externalizable small form.
+const (
+	AsyncMessageExtClass       = "DSA"
+	AcknowledgeMessageExtClass = "DSK"
+	CommandMessageExtClass     = "DSC"
+)
+
+func init() {
+	amf3.RegisterExternalizable(AsyncMessageExtClass, func(dec *amf3.Decoder) (interface{}, error) {
+		m := new(AsyncMessage)
+		return m, m.readExternal(dec)
+	}, func(enc *amf3.Encoder, v interface{}) error {
+		m, ok := v.(*AsyncMessage)
+		if !ok {
+			return fmt.Errorf("flex: cannot write %T as DSA", v)
+		}
+		return m.writeExternal(enc)
+	})
+	amf3.RegisterExternalizable(AcknowledgeMessageExtClass, func(dec *amf3.Decoder) (interface{}, error) {
+		m := new(AcknowledgeMessage)
+		return m, m.readExternal(dec)
+	}, func(enc *amf3.Encoder, v interface{}) error {
+		m, ok := v.(*AcknowledgeMessage)
+		if !ok {
+			return fmt.Errorf("flex: cannot write %T as DSK", v)
+		}
+		return m.writeExternal(enc)
+	})
+	amf3.RegisterExternalizable(CommandMessageExtClass, func(dec *amf3.Decoder) (interface{}, error) {
+		m := new(CommandMessage)
+		return m, m.readExternal(dec)
+	}, func(enc *amf3.Encoder, v interface{}) error {
+		m, ok := v.(*CommandMessage)
+		if !ok {
+			return fmt.Errorf("flex: cannot write %T as DSC", v)
+		}
+		return m.writeExternal(enc)
+	})
+}
+
+// SmallMessage returns m in its DSA form, to be encoded in place of m for
+// clients that take small messages.
+func (m *AsyncMessage) SmallMessage() interface{} {
+	return smallMessage(AsyncMessageExtClass, m)
+}
+
+// SmallMessage returns m in its DSK form, to be encoded in place of m for
+// clients that take small messages.
+func (m *AcknowledgeMessage) SmallMessage() interface{} {
+	return smallMessage(AcknowledgeMessageExtClass, m)
+}
+
+// SmallMessage returns m in its DSC form, to be encoded in place of m for
+// clients that take small messages.
+func (m *CommandMessage) SmallMessage() interface{} {
+	return smallMessage(CommandMessageExtClass, m)
+}
+
+// SmallMessage returns m itself, as an ErrorMessage has no small form.
+func (m *ErrorMessage) SmallMessage() interface{} {
+	return m
+}
+
+func smallMessage(class string, m interface{}) *amf3.ObjectType {
+	return &amf3.ObjectType{
+		Trait:    &amf3.Trait{ClassName: amf3.StringType(class), IsExternalizable: true},
+		External: m,
+	}
+}
+
+// A small message is written as groups of members, each group preceded by
+// flag bytes telling which of its members follow. The high bit of a flag
+// byte tells that another flag byte follows.
+const hasNextFlag = 0x80
+
+// Flags of AbstractMessage.
+const (
+	bodyFlag        = 0x01
+	clientIDFlag    = 0x02
+	destinationFlag = 0x04
+	headersFlag     = 0x08
+	messageIDFlag   = 0x10
+	timestampFlag   = 0x20
+	timeToLiveFlag  = 0x40
+
+	// in the second flag byte
+	clientIDBytesFlag  = 0x01
+	messageIDBytesFlag = 0x02
+)
+
+// Flags of AsyncMessage.
+const (
+	correlationIDFlag      = 0x01
+	correlationIDBytesFlag = 0x02
+)
+
+// Flags of CommandMessage.
+const (
+	operationFlag = 0x01
+)
+
+func readFlags(dec *amf3.Decoder) ([]byte, error) {
+	var flags []byte
+	for {
+		b, err := dec.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		flags = append(flags, b)
+		if b&hasNextFlag == 0 {
+			return flags, nil
+		}
+	}
+}
+
+// skipMembers reads and drops the members flagged from bit reserved on,
+// which newer versions of a message may add.
+func skipMembers(dec *amf3.Decoder, flags byte, reserved uint) error {
+	for i := reserved; i < 6; i++ {
+		if flags>>i&1 != 0 {
+			_, err := dec.DecodeWire()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// skipGroups reads and drops the members of all groups, for messages whose
+// own members all come from newer versions.
+func skipGroups(dec *amf3.Decoder) error {
+	flags, err := readFlags(dec)
+	if err != nil {
+		return err
+	}
+	for _, f := range flags {
+		err = skipMembers(dec, f, 0)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *AbstractMessage) readExternal(dec *amf3.Decoder) error {
+	flags, err := readFlags(dec)
+	if err != nil {
+		return err
+	}
+	for i, f := range flags {
+		var reserved uint
+		switch i {
+		case 0:
+			for _, member := range []struct {
+				flag byte
+				read func(v interface{})
+			}{
+				{bodyFlag, func(v interface{}) { m.Body = v }},
+				{clientIDFlag, func(v interface{}) { m.ClientID = toString(v) }},
+				{destinationFlag, func(v interface{}) { m.Destination = toString(v) }},
+				{headersFlag, func(v interface{}) { m.Headers = toMap(v) }},
+				{messageIDFlag, func(v interface{}) { m.MessageID = toString(v) }},
+				{timestampFlag, func(v interface{}) { m.Timestamp = toNumber(v) }},
+				{timeToLiveFlag, func(v interface{}) { m.TimeToLive = toNumber(v) }},
+			} {
+				if f&member.flag == 0 {
+					continue
+				}
+				v, err := dec.DecodeWire()
+				if err != nil {
+					return err
+				}
+				member.read(v)
+			}
+			if isNull(m.Body) {
+				m.Body = nil
+			}
+			reserved = 7
+		case 1:
+			if f&clientIDBytesFlag != 0 {
+				m.ClientID, err = readUID(dec)
+				if err != nil {
+					return err
+				}
+			}
+			if f&messageIDBytesFlag != 0 {
+				m.MessageID, err = readUID(dec)
+				if err != nil {
+					return err
+				}
+			}
+			reserved = 2
+		}
+		err = skipMembers(dec, f, reserved)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeExternal writes the members of m. The ids are written as strings,
+// which readers take as well as the 16 byte form.
+func (m *AbstractMessage) writeExternal(enc *amf3.Encoder) error {
+	var flags byte
+	var values []interface{}
+	if m.Body != nil {
+		flags |= bodyFlag
+		values = append(values, m.Body)
+	}
+	if m.ClientID != "" {
+		flags |= clientIDFlag
+		values = append(values, amf3.StringType(m.ClientID))
+	}
+	if m.Destination != "" {
+		flags |= destinationFlag
+		values = append(values, amf3.StringType(m.Destination))
+	}
+	if m.Headers != nil {
+		flags |= headersFlag
+		values = append(values, m.Headers)
+	}
+	if m.MessageID != "" {
+		flags |= messageIDFlag
+		values = append(values, amf3.StringType(m.MessageID))
+	}
+	if m.Timestamp != 0 {
+		flags |= timestampFlag
+		values = append(values, amf3.DoubleType(m.Timestamp))
+	}
+	if m.TimeToLive != 0 {
+		flags |= timeToLiveFlag
+		values = append(values, amf3.DoubleType(m.TimeToLive))
+	}
+	return writeGroup(enc, flags, values)
+}
+
+func writeGroup(enc *amf3.Encoder, flags byte, values []interface{}) error {
+	err := enc.WriteByte(flags)
+	if err != nil {
+		return err
+	}
+	for _, v := range values {
+		err = enc.EncodeValue(v)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *AsyncMessage) readExternal(dec *amf3.Decoder) error {
+	err := m.AbstractMessage.readExternal(dec)
+	if err != nil {
+		return err
+	}
+	flags, err := readFlags(dec)
+	if err != nil {
+		return err
+	}
+	for i, f := range flags {
+		var reserved uint
+		if i == 0 {
+			if f&correlationIDFlag != 0 {
+				v, err := dec.DecodeWire()
+				if err != nil {
+					return err
+				}
+				m.CorrelationID = toString(v)
+			}
+			if f&correlationIDBytesFlag != 0 {
+				m.CorrelationID, err = readUID(dec)
+				if err != nil {
+					return err
+				}
+			}
+			reserved = 2
+		}
+		err = skipMembers(dec, f, reserved)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *AsyncMessage) writeExternal(enc *amf3.Encoder) error {
+	err := m.AbstractMessage.writeExternal(enc)
+	if err != nil {
+		return err
+	}
+	if m.CorrelationID == "" {
+		return writeGroup(enc, 0, nil)
+	}
+	return writeGroup(enc, correlationIDFlag, []interface{}{amf3.StringType(m.CorrelationID)})
+}
+
+func (m *AcknowledgeMessage) readExternal(dec *amf3.Decoder) error {
+	err := m.AsyncMessage.readExternal(dec)
+	if err != nil {
+		return err
+	}
+	return skipGroups(dec)
+}
+
+func (m *AcknowledgeMessage) writeExternal(enc *amf3.Encoder) error {
+	err := m.AsyncMessage.writeExternal(enc)
+	if err != nil {
+		return err
+	}
+	return writeGroup(enc, 0, nil)
+}
+
+func (m *CommandMessage) readExternal(dec *amf3.Decoder) error {
+	err := m.AsyncMessage.readExternal(dec)
+	if err != nil {
+		return err
+	}
+	flags, err := readFlags(dec)
+	if err != nil {
+		return err
+	}
+	for i, f := range flags {
+		var reserved uint
+		if i == 0 {
+			if f&operationFlag != 0 {
+				v, err := dec.DecodeWire()
+				if err != nil {
+					return err
+				}
+				m.Operation = int(toNumber(v))
+			}
+			reserved = 1
+		}
+		err = skipMembers(dec, f, reserved)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *CommandMessage) writeExternal(enc *amf3.Encoder) error {
+	err := m.AsyncMessage.writeExternal(enc)
+	if err != nil {
+		return err
+	}
+	if m.Operation == 0 {
+		return writeGroup(enc, 0, nil)
+	}
+	return writeGroup(enc, operationFlag, []interface{}{amf3.IntegerType(m.Operation)})
+}
+
+// readUID reads an id sent as 16 bytes and formats it as Flex does.
+func readUID(dec *amf3.Decoder) (string, error) {
+	v, err := dec.DecodeWire()
+	if err != nil {
+		return "", err
+	}
+	b, ok := v.(*amf3.ByteArrayType)
+	if !ok || len(*b) != 16 {
+		return "", errors.New("flex: id is not 16 bytes")
+	}
+	return formatUID(*b), nil
+}
+
+func formatUID(b []byte) string {
+	return fmt.Sprintf("%X-%X-%X-%X-%X", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,291 @@
+package amf
+
+import (
+	"context"
+	"errors"
+	"github.com/hongruiqi/amf.go/amf0"
+	"github.com/hongruiqi/amf.go/amf3"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Fault is the status a Flash Remoting call fails with, sent as the body of
+// an onStatus response. Methods served by a Gateway may return a *Fault to
+// choose the code; other errors are reported as Server.Call.Failed.
+type Fault struct {
+	Level       string `amf:"level"`
+	Code        string `amf:"code"`
+	Description string `amf:"description"`
+	Details     string `amf:"details,omitempty"`
+}
+
+func (f *Fault) Error() string {
+	return "amf: " + f.Code + ": " + f.Description
+}
+
+func newFault(code, description string) *Fault {
+	return &Fault{Level: "error", Code: code, Description: description}
+}
+
+// errorFault returns err as a *Fault, reporting other errors as
+// Server.Call.Failed.
+func errorFault(err error) *Fault {
+	if fault, ok := err.(*Fault); ok {
+		return fault
+	}
+	return newFault("Server.Call.Failed", err.Error())
+}
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// Gateway is an http.Handler for application/x-amf requests, as posted by
+// NetConnection.call or a Flex RemoteObject. It calls the methods of
+// registered receivers for the messages of a request and answers each with
+// an onResult or onStatus message. The zero value is ready to use, with
+// limits safe for requests from untrusted clients.
+type Gateway struct {
+	// Limits bound each header and message value of a request. When zero,
+	// DefaultGatewayLimits apply.
+	Limits amf0.DecoderLimits
+
+	// MaxBodySize bounds the bytes of a request, which is rejected with
+	// status 413 when longer. When zero, DefaultMaxBodySize applies.
+	MaxBodySize int64
+
+	mu       sync.RWMutex
+	services map[string]*service
+}
+
+// DefaultGatewayLimits are the limits of a Gateway whose Limits are zero.
+// The depth keeps nested values from exhausting the stack.
+var DefaultGatewayLimits = amf0.DecoderLimits{
+	MaxDepth:            64,
+	MaxStringLength:     1 << 20,
+	MaxCollectionLength: 1 << 16,
+	MaxReferences:       1 << 16,
+}
+
+// DefaultMaxBodySize is the request size limit of a Gateway whose
+// MaxBodySize is zero.
+const DefaultMaxBodySize = 8 << 20
+
+type service struct {
+	rcvr    reflect.Value
+	methods map[string]reflect.Method
+}
+
+// Register makes the exported methods of rcvr callable with target URIs
+// "name.method", or by RemotingMessages to destination name, where the
+// method name may start in lower case as is usual in ActionScript. Methods
+// may take a context.Context first, which is the context of the request,
+// followed by parameters converted from the call arguments as by
+// Unmarshal. They may return nothing, a result, an error, or a result
+// followed by an error; results are encoded as by Marshal.
+func (g *Gateway) Register(name string, rcvr interface{}) error {
+	if name == "" {
+		return errors.New("amf: Register needs a service name")
+	}
+	s := &service{rcvr: reflect.ValueOf(rcvr), methods: make(map[string]reflect.Method)}
+	t := reflect.TypeOf(rcvr)
+	if t == nil {
+		return errors.New("amf: Register needs a receiver")
+	}
+	for i := 0; i < t.NumMethod(); i++ {
+		method := t.Method(i)
+		if !suitableMethod(method.Type) {
+			continue
+		}
+		s.methods[method.Name] = method
+		s.methods[lowerFirst(method.Name)] = method
+	}
+	if len(s.methods) == 0 {
+		return errors.New("amf: type " + t.String() + " has no methods to serve")
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.services == nil {
+		g.services = make(map[string]*service)
+	}
+	if _, ok := g.services[name]; ok {
+		return errors.New("amf: service " + name + " already registered")
+	}
+	g.services[name] = s
+	return nil
+}
+
+// suitableMethod reports whether a method, with its receiver as the first
+// parameter, has results a Gateway can send.
+func suitableMethod(t reflect.Type) bool {
+	if t.IsVariadic() {
+		return false
+	}
+	switch t.NumOut() {
+	case 0, 1:
+		return true
+	case 2:
+		return t.Out(1) == errorType
+	}
+	return false
+}
+
+func lowerFirst(s string) string {
+	r, n := utf8.DecodeRuneInString(s)
+	return string(unicode.ToLower(r)) + s[n:]
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	limits := g.Limits
+	if limits == (amf0.DecoderLimits{}) {
+		limits = DefaultGatewayLimits
+	}
+	maxBodySize := g.MaxBodySize
+	if maxBodySize == 0 {
+		maxBodySize = DefaultMaxBodySize
+	}
+	req, err := readPacket(http.MaxBytesReader(w, r.Body, maxBodySize), limits)
+	if err != nil {
+		status := http.StatusBadRequest
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	resp := &Packet{Version: req.Version}
+	for _, m := range req.Messages {
+		var result interface{}
+		status := "/onResult"
+		version := req.Version
+		if m.TargetURI == "null" {
+			var ok bool
+			result, ok = g.serveFlex(r.Context(), m.Body)
+			if !ok {
+				status = "/onStatus"
+			}
+			// Flex messages are AMF3 objects, whatever the packet version
+			version = AMF3
+		} else {
+			result, err = g.call(r.Context(), m)
+			if err != nil {
+				result, status = errorFault(err), "/onStatus"
+			}
+		}
+		body, err := marshalBody(result, version)
+		if err != nil {
+			body, err = marshalBody(newFault("Server.Call.Failed", err.Error()), version)
+			status = "/onStatus"
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if version == AMF3 {
+			body = amf0.AvmPlusObjectType{Value: body}
+		}
+		resp.Messages = append(resp.Messages, Message{
+			TargetURI:   m.ResponseURI + status,
+			ResponseURI: "null",
+			Body:        body,
+		})
+	}
+	data, err := AppendPacket(nil, resp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-amf")
+	w.Write(data)
+}
+
+// marshalBody converts a Go value into wire types for a packet body of the
+// given version.
+func marshalBody(v interface{}, version uint16) (interface{}, error) {
+	m := &marshaler{seen: make(map[seenKey]interface{})}
+	if version == AMF3 {
+		return m.toAMF3(reflect.ValueOf(v))
+	}
+	return m.toAMF0(reflect.ValueOf(v))
+}
+
+// call calls the method targeted by m with its arguments.
+func (g *Gateway) call(ctx context.Context, m Message) (interface{}, error) {
+	dot := strings.LastIndexByte(m.TargetURI, '.')
+	if dot < 0 {
+		return nil, newFault("Server.ResourceNotFound", "no method in target "+m.TargetURI)
+	}
+	return g.invoke(ctx, m.TargetURI[:dot], m.TargetURI[dot+1:], m.Body)
+}
+
+// invoke calls a method of a registered service with the arguments in body.
+func (g *Gateway) invoke(ctx context.Context, serviceName, methodName string, body interface{}) (interface{}, error) {
+	target := serviceName + "." + methodName
+	g.mu.RLock()
+	s, ok := g.services[serviceName]
+	g.mu.RUnlock()
+	if !ok {
+		return nil, newFault("Server.ResourceNotFound", "unknown service "+serviceName)
+	}
+	method, ok := s.methods[methodName]
+	if !ok {
+		return nil, newFault("Server.ResourceNotFound", "unknown method "+target)
+	}
+	args, err := callArguments(body)
+	if err != nil {
+		return nil, err
+	}
+	t := method.Type
+	in := []reflect.Value{s.rcvr}
+	if t.NumIn() > 1 && t.In(1) == contextType {
+		in = append(in, reflect.ValueOf(ctx))
+	}
+	if t.NumIn()-len(in) != len(args) {
+		return nil, newFault("Server.Call.BadArguments", "wrong number of arguments for "+target)
+	}
+	u := &unmarshaler{seen: make(map[unmarshalKey]reflect.Value)}
+	for _, arg := range args {
+		v := reflect.New(t.In(len(in))).Elem()
+		err = u.assign(v, arg)
+		if err != nil {
+			return nil, newFault("Server.Call.BadArguments", err.Error())
+		}
+		in = append(in, v)
+	}
+	out := method.Func.Call(in)
+	if len(out) > 0 && out[len(out)-1].Type() == errorType {
+		if err, _ := out[len(out)-1].Interface().(error); err != nil {
+			return nil, err
+		}
+		out = out[:len(out)-1]
+	}
+	if len(out) == 0 {
+		return nil, nil
+	}
+	return out[0].Interface(), nil
+}
+
+// callArguments returns the arguments a call body carries.
+func callArguments(body interface{}) ([]interface{}, error) {
+	switch b := body.(type) {
+	case *amf0.StrictArrayType:
+		return *b, nil
+	case *amf3.ArrayType:
+		return b.Dense, nil
+	}
+	if isNull(body) {
+		return nil, nil
+	}
+	return nil, newFault("Server.Call.BadArguments", "call arguments are not an array")
+}
@@ -0,0 +1,244 @@
+package amf
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/hongruiqi/amf.go/amf0"
+	"github.com/hongruiqi/amf.go/amf3"
+	"github.com/hongruiqi/amf.go/flex"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+type testService struct{}
+
+func (testService) Echo(s string) string {
+	return s
+}
+
+func (testService) Add(a, b int) int {
+	return a + b
+}
+
+func (testService) Greet(ctx context.Context, u testUser) (string, error) {
+	if ctx == nil {
+		return "", errors.New("no context")
+	}
+	return "hello " + u.Name, nil
+}
+
+func (testService) Fail() error {
+	return errors.New("broken")
+}
+
+func (testService) Deny() (int, error) {
+	return 0, &Fault{Level: "error", Code: "Client.Auth", Description: "denied"}
+}
+
+func postPacket(t *testing.T, url string, p *Packet) *Packet {
+	data, err := AppendPacket(nil, p)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	resp, err := http.Post(url, "application/x-amf", bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/x-amf" {
+		t.Fatalf("got status %s, content type %s", resp.Status, resp.Header.Get("Content-Type"))
+	}
+	got, err := ReadPacket(resp.Body)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	return got
+}
+
+func TestGateway(t *testing.T) {
+	g := new(Gateway)
+	err := g.Register("test.Service", testService{})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	server := httptest.NewServer(g)
+	defer server.Close()
+
+	got := postPacket(t, server.URL, &Packet{Version: AMF0, Messages: []Message{
+		{TargetURI: "test.Service.echo", ResponseURI: "/1", Body: []interface{}{"foo"}},
+		{TargetURI: "test.Service.Add", ResponseURI: "/2", Body: []interface{}{1, 2}},
+		{TargetURI: "test.Service.greet", ResponseURI: "/3", Body: []interface{}{map[string]interface{}{"name": "bar"}}},
+	}})
+	expect := []Message{
+		{TargetURI: "/1/onResult", ResponseURI: "null", Body: amf0.StringType("foo")},
+		{TargetURI: "/2/onResult", ResponseURI: "null", Body: amf0.NumberType(3)},
+		{TargetURI: "/3/onResult", ResponseURI: "null", Body: amf0.StringType("hello bar")},
+	}
+	if got.Version != AMF0 || !reflect.DeepEqual(got.Messages, expect) {
+		t.Errorf("expect %+v got %+v", expect, got.Messages)
+	}
+
+	got = postPacket(t, server.URL, &Packet{Version: AMF3, Messages: []Message{
+		{TargetURI: "test.Service.echo", ResponseURI: "/1", Body: []interface{}{"foo"}},
+	}})
+	expect = []Message{
+		{TargetURI: "/1/onResult", ResponseURI: "null", Body: amf3.StringType("foo")},
+	}
+	if got.Version != AMF3 || !reflect.DeepEqual(got.Messages, expect) {
+		t.Errorf("expect %+v got %+v", expect, got.Messages)
+	}
+}
+
+func TestGatewayStatus(t *testing.T) {
+	g := new(Gateway)
+	err := g.Register("Service", testService{})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	server := httptest.NewServer(g)
+	defer server.Close()
+
+	tests := []struct {
+		target string
+		args   interface{}
+		code   string
+	}{
+		{"Service.fail", nil, "Server.Call.Failed"},
+		{"Service.deny", nil, "Client.Auth"},
+		{"Service.missing", nil, "Server.ResourceNotFound"},
+		{"Missing.echo", nil, "Server.ResourceNotFound"},
+		{"Service.echo", []interface{}{}, "Server.Call.BadArguments"},
+		{"Service.add", []interface{}{"a", "b"}, "Server.Call.BadArguments"},
+	}
+	p := &Packet{}
+	for _, test := range tests {
+		p.Messages = append(p.Messages, Message{TargetURI: test.target, ResponseURI: "/1", Body: test.args})
+	}
+	got := postPacket(t, server.URL, p)
+	if len(got.Messages) != len(tests) {
+		t.Fatalf("expect %d messages got %d", len(tests), len(got.Messages))
+	}
+	for i, test := range tests {
+		m := got.Messages[i]
+		obj, ok := m.Body.(*amf0.ObjectType)
+		if m.TargetURI != "/1/onStatus" || !ok || (*obj)["code"] != amf0.StringType(test.code) || (*obj)["level"] != amf0.StringType("error") {
+			t.Errorf("%s: expect status %s got %s %+v", test.target, test.code, m.TargetURI, m.Body)
+		}
+	}
+}
+
+func TestGatewayBadRequest(t *testing.T) {
+	g := new(Gateway)
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader([]byte{0x00, 0x00, 0x00}))
+	w := httptest.NewRecorder()
+	g.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("truncated packet: expect status 400 got %d", w.Code)
+	}
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	w = httptest.NewRecorder()
+	g.ServeHTTP(w, req)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: expect status 405 got %d", w.Code)
+	}
+}
+
+func TestGatewayDefaultLimits(t *testing.T) {
+	g := new(Gateway)
+	data := []byte{0x00, 0x03, 0x00, 0x00, 0x00, 0x01,
+		0x00, 0x03, 'a', '.', 'b', 0x00, 0x02, '/', '1', 0xff, 0xff, 0xff, 0xff,
+		0x11}
+	// arrays nested far deeper than the stack allows to decode
+	data = append(data, bytes.Repeat([]byte{0x09, 0x03, 0x01}, 1<<20)...)
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data))
+	w := httptest.NewRecorder()
+	g.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("nested arrays: expect status 400 got %d", w.Code)
+	}
+
+	g.MaxBodySize = 16
+	req = httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data))
+	w = httptest.NewRecorder()
+	g.ServeHTTP(w, req)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("long body: expect status 413 got %d", w.Code)
+	}
+}
+
+func TestGatewayRegister(t *testing.T) {
+	g := new(Gateway)
+	err := g.Register("Service", testService{})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err = g.Register("Service", testService{}); err == nil {
+		t.Errorf("expect error registering a service twice")
+	}
+	if err = g.Register("Empty", struct{}{}); err == nil {
+		t.Errorf("expect error registering a receiver without methods")
+	}
+}
+
+func TestGatewayFlex(t *testing.T) {
+	g := new(Gateway)
+	err := g.Register("Service", testService{})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	server := httptest.NewServer(g)
+	defer server.Close()
+
+	ping := new(flex.CommandMessage)
+	ping.Operation = flex.ClientPingOperation
+	ping.MessageID = "ping"
+	ping.Headers = map[string]interface{}{flex.FlexClientIDHeader: "nil"}
+	call := new(flex.RemotingMessage)
+	call.Destination = "Service"
+	call.Operation = "add"
+	call.MessageID = "call"
+	call.Body = []interface{}{1, 2}
+	missing := new(flex.RemotingMessage)
+	missing.Destination = "Missing"
+	missing.Operation = "echo"
+	missing.MessageID = "missing"
+	// Flex replies are AMF3 even in an AMF0 packet
+	for _, version := range []uint16{AMF0, AMF3} {
+		got := postPacket(t, server.URL, &Packet{Version: version, Messages: []Message{
+			{TargetURI: "null", ResponseURI: "/1", Body: []interface{}{ping.SmallMessage()}},
+			{TargetURI: "null", ResponseURI: "/2", Body: []interface{}{call}},
+			{TargetURI: "null", ResponseURI: "/3", Body: []interface{}{missing}},
+		}})
+		if len(got.Messages) != 3 {
+			t.Fatalf("version %d: expect 3 messages got %d", version, len(got.Messages))
+		}
+		u := &unmarshaler{seen: make(map[unmarshalKey]reflect.Value)}
+
+		m := got.Messages[0]
+		if obj, ok := m.Body.(*amf3.ObjectType); !ok || obj.Trait.ClassName != flex.AcknowledgeMessageExtClass {
+			t.Errorf("version %d: ping: expect a small acknowledgement got %+v", version, m.Body)
+		}
+		var ack flex.AcknowledgeMessage
+		err = u.assign(reflect.ValueOf(&ack).Elem(), m.Body)
+		if m.TargetURI != "/1/onResult" || err != nil || ack.CorrelationID != "ping" || ack.Headers[flex.FlexClientIDHeader] == nil {
+			t.Errorf("version %d: ping: got %s %+v, %v", version, m.TargetURI, ack, err)
+		}
+
+		m = got.Messages[1]
+		ack = flex.AcknowledgeMessage{}
+		err = u.assign(reflect.ValueOf(&ack).Elem(), m.Body)
+		if m.TargetURI != "/2/onResult" || err != nil || ack.CorrelationID != "call" || ack.Body != amf3.IntegerType(3) {
+			t.Errorf("version %d: call: got %s %+v, %v", version, m.TargetURI, ack, err)
+		}
+
+		m = got.Messages[2]
+		var errMsg flex.ErrorMessage
+		err = u.assign(reflect.ValueOf(&errMsg).Elem(), m.Body)
+		if m.TargetURI != "/3/onStatus" || err != nil || errMsg.CorrelationID != "missing" || errMsg.FaultCode != "Server.ResourceNotFound" {
+			t.Errorf("version %d: missing: got %s %+v, %v", version, m.TargetURI, errMsg, err)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package stream
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Path holds the member names and item indexes leading to the value being
+// decoded, for error reports.
+type Path []interface{}
+
+// PushMember enters the member name.
+func (p *Path) PushMember(name string) {
+	*p = append(*p, name)
+}
+
+// PushItem enters the item at index i.
+func (p *Path) PushItem(i int) {
+	*p = append(*p, i)
+}
+
+// Pop leaves the last member or item entered.
+func (p *Path) Pop() {
+	*p = (*p)[:len(*p)-1]
+}
+
+// String formats the path as in users[2].roles.
+func (p Path) String() string {
+	var b strings.Builder
+	for _, elem := range p {
+		switch elem := elem.(type) {
+		case int:
+			fmt.Fprintf(&b, "[%d]", elem)
+		case string:
+			if b.Len() > 0 {
+				b.WriteByte('.')
+			}
+			b.WriteString(elem)
+		}
+	}
+	return b.String()
+}
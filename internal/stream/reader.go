@@ -0,0 +1,123 @@
+// Package stream holds the input and output of the amf0 and amf3 encoders
+// and decoders, which an amf0 stream hands on to AMF3 for values switched to
+// it.
+package stream
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrLimitExceeded is returned when the input goes past a decoder limit.
+var ErrLimitExceeded = errors.New("limit exceeded")
+
+// Reader is the input of a decoder, an io.Reader or, when R is nil, the
+// byte slice B. It counts the bytes consumed and enforces the byte budget.
+// Every read of the decoder asks for exactly the bytes it needs, so a read
+// going past the budget fails as a whole.
+type Reader struct {
+	R       io.Reader
+	B       []byte
+	Off     int64 // bytes read so far
+	Limit   int64 // offset reads may not go past, or 0
+	scratch [8]byte
+}
+
+func (r *Reader) checkBudget(n int) error {
+	if r.Limit > 0 && int64(n) > r.Limit-r.Off {
+		return fmt.Errorf("%w: value longer than budget", ErrLimitExceeded)
+	}
+	return nil
+}
+
+// Next returns the next n bytes without copying. Unless reading from a byte
+// slice, n may not exceed the scratch space and the result is only valid
+// until the next read.
+func (r *Reader) Next(n int) ([]byte, error) {
+	err := r.checkBudget(n)
+	if err != nil {
+		return nil, err
+	}
+	if r.R == nil {
+		rest := r.B[r.Off:]
+		if n < 0 || len(rest) < n {
+			r.Off += int64(len(rest))
+			if len(rest) == 0 {
+				return nil, io.EOF
+			}
+			return nil, io.ErrUnexpectedEOF
+		}
+		r.Off += int64(n)
+		return rest[:n:n], nil
+	}
+	b := r.scratch[:n]
+	m, err := io.ReadFull(r.R, b)
+	r.Off += int64(m)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	err := r.checkBudget(len(p))
+	if err != nil {
+		return 0, err
+	}
+	if r.R == nil {
+		if len(p) > 0 && r.Off == int64(len(r.B)) {
+			return 0, io.EOF
+		}
+		n := copy(p, r.B[r.Off:])
+		r.Off += int64(n)
+		return n, nil
+	}
+	n, err := r.R.Read(p)
+	r.Off += int64(n)
+	return n, err
+}
+
+func (r *Reader) ReadByte() (byte, error) {
+	b, err := r.Next(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// maxPrealloc bounds the room reserved up front for a length or count read
+// off the wire. Longer values grow as their data actually arrives, so a short
+// malformed input cannot force a large allocation.
+const maxPrealloc = 4096
+
+// CapHint returns the capacity to reserve for n items read off the wire.
+func CapHint(n uint32) int {
+	if n > maxPrealloc {
+		return maxPrealloc
+	}
+	return int(n)
+}
+
+// ReadBytes reads the next n bytes into a slice of their own, or, when
+// reading from a byte slice, returns them without copying.
+func (r *Reader) ReadBytes(n uint32) ([]byte, error) {
+	if r.R == nil {
+		return r.Next(int(n))
+	}
+	if n <= maxPrealloc {
+		b := make([]byte, n)
+		_, err := io.ReadFull(r, b)
+		if err != nil {
+			return nil, err
+		}
+		return b, nil
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, maxPrealloc))
+	_, err := io.CopyN(buf, r, int64(n))
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
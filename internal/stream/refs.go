@@ -0,0 +1,34 @@
+package stream
+
+import (
+	"reflect"
+	"unsafe"
+)
+
+// nativeRef identifies a Go map or slice in the reference table of an
+// encoder. It holds the pointer itself, not its address, so a value stays
+// alive while the encoder may still refer to it and no later one can take
+// its place.
+type nativeRef struct {
+	ptr unsafe.Pointer
+	len int
+}
+
+// emptyRef holds the place of an empty slice in the reference table. Empty
+// slices may all share one address, so each gets a key of its own, its
+// index, which no other value is looked up by.
+type emptyRef int
+
+// MapRef returns the reference table key of the map v.
+func MapRef(v reflect.Value) interface{} {
+	return nativeRef{v.UnsafePointer(), -1}
+}
+
+// SliceRef returns the reference table key of the slice v, which would be
+// entry n of the table.
+func SliceRef(v reflect.Value, n int) interface{} {
+	if v.Len() == 0 {
+		return emptyRef(n)
+	}
+	return nativeRef{v.UnsafePointer(), v.Len()}
+}
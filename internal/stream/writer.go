@@ -0,0 +1,61 @@
+package stream
+
+import (
+	"bufio"
+	"io"
+)
+
+// Writer is the output of an encoder: a bufio.Writer over the io.Writer
+// given to it, that writer itself as a directWriter, or a SliceWriter.
+type Writer interface {
+	byteStringWriter
+	Flush() error
+}
+
+type byteStringWriter interface {
+	io.Writer
+	io.ByteWriter
+	io.StringWriter
+}
+
+// NewWriter returns the Writer for w. Writers that take single bytes and
+// strings are written to directly and left to their owner to flush; others
+// are buffered.
+func NewWriter(w io.Writer) Writer {
+	if bsw, ok := w.(byteStringWriter); ok {
+		return directWriter{bsw}
+	}
+	return bufio.NewWriter(w)
+}
+
+type directWriter struct {
+	byteStringWriter
+}
+
+func (w directWriter) Flush() error {
+	return nil
+}
+
+// SliceWriter appends to the byte slice B.
+type SliceWriter struct {
+	B []byte
+}
+
+func (w *SliceWriter) Write(p []byte) (int, error) {
+	w.B = append(w.B, p...)
+	return len(p), nil
+}
+
+func (w *SliceWriter) WriteByte(c byte) error {
+	w.B = append(w.B, c)
+	return nil
+}
+
+func (w *SliceWriter) WriteString(s string) (int, error) {
+	w.B = append(w.B, s...)
+	return len(s), nil
+}
+
+func (w *SliceWriter) Flush() error {
+	return nil
+}
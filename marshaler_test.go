@@ -0,0 +1,127 @@
+package amf
+
+import (
+	"bytes"
+	"errors"
+	"github.com/hongruiqi/amf.go/amf0"
+	"github.com/hongruiqi/amf.go/amf3"
+	"github.com/hongruiqi/amf.go/flex"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// testMoney is written as a string of cents.
+type testMoney struct {
+	cents int64
+}
+
+func (m testMoney) MarshalAMF0(enc *amf0.Encoder) error {
+	return enc.EncodeValue(amf0.StringType(strconv.FormatInt(m.cents, 10)))
+}
+
+func (m *testMoney) UnmarshalAMF0(dec *amf0.Decoder) error {
+	v, err := dec.Decode()
+	if err != nil {
+		return err
+	}
+	s, ok := v.(amf0.StringType)
+	if !ok {
+		return errors.New("money should be a string")
+	}
+	m.cents, err = strconv.ParseInt(string(s), 10, 64)
+	return err
+}
+
+func (m testMoney) MarshalAMF3(enc *amf3.Encoder) error {
+	return enc.EncodeValue(amf3.StringType(strconv.FormatInt(m.cents, 10)))
+}
+
+func (m *testMoney) UnmarshalAMF3(dec *amf3.Decoder) error {
+	v, err := dec.Decode()
+	if err != nil {
+		return err
+	}
+	s, ok := v.(amf3.StringType)
+	if !ok {
+		return errors.New("money should be a string")
+	}
+	m.cents, err = strconv.ParseInt(string(s), 10, 64)
+	return err
+}
+
+type testOrder struct {
+	Price testMoney  `amf:"price"`
+	Tax   *testMoney `amf:"tax"`
+}
+
+func TestMarshalerUnmarshaler(t *testing.T) {
+	for _, version := range []int{AMF0, AMF3} {
+		data, err := Marshal(testOrder{Price: testMoney{1999}, Tax: &testMoney{150}}, version)
+		if err != nil {
+			t.Fatalf("version %d: %s", version, err)
+		}
+		var raw interface{}
+		err = Unmarshal(data, &raw, version)
+		if err != nil {
+			t.Fatalf("version %d: %s", version, err)
+		}
+		if m, ok := raw.(map[string]interface{}); !ok || m["price"] != "1999" {
+			t.Errorf("version %d: money not written as string: %v", version, raw)
+		}
+		var got testOrder
+		err = Unmarshal(data, &got, version)
+		if err != nil {
+			t.Fatalf("version %d: %s", version, err)
+		}
+		if got.Price.cents != 1999 || got.Tax == nil || got.Tax.cents != 150 {
+			t.Errorf("version %d: decode incorrect %+v", version, got)
+		}
+	}
+}
+
+func TestMarshalerNilPointer(t *testing.T) {
+	for _, version := range []int{AMF0, AMF3} {
+		data, err := Marshal(testOrder{Price: testMoney{5}}, version)
+		if err != nil {
+			t.Fatalf("version %d: %s", version, err)
+		}
+		var got testOrder
+		err = Unmarshal(data, &got, version)
+		if err != nil {
+			t.Fatalf("version %d: %s", version, err)
+		}
+		if got.Price.cents != 5 || got.Tax != nil {
+			t.Errorf("version %d: decode incorrect %+v", version, got)
+		}
+	}
+}
+
+func TestMarshalArrayCollection(t *testing.T) {
+	users := []testUser{{Name: "a"}, {Name: "b", Age: 2}}
+	for _, version := range []int{AMF0, AMF3} {
+		data, err := Marshal(&flex.ArrayCollection{Source: users}, version)
+		if err != nil {
+			t.Fatalf("version %d: %s", version, err)
+		}
+		if version == AMF3 && !bytes.Contains(data, []byte(flex.ArrayCollectionClass)) {
+			t.Errorf("version %d: no ArrayCollection in %x", version, data)
+		}
+		var got []testUser
+		err = Unmarshal(data, &got, version)
+		if err != nil {
+			t.Fatalf("version %d: %s", version, err)
+		}
+		if !reflect.DeepEqual(users, got) {
+			t.Errorf("version %d: expect %+v got %+v", version, users, got)
+		}
+		var raw interface{}
+		err = Unmarshal(data, &raw, version)
+		if err != nil {
+			t.Fatalf("version %d: %s", version, err)
+		}
+		if s, ok := raw.([]interface{}); !ok || len(s) != 2 {
+			t.Errorf("version %d: expect a slice got %#v", version, raw)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package amf
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/hongruiqi/amf.go/amf0"
+	"io"
+	"reflect"
+)
+
+// Packet is the envelope Flash Remoting posts over HTTP as
+// application/x-amf: headers that apply to the whole request, followed by
+// messages that each carry the body of one call or response.
+type Packet struct {
+	Version  uint16 // AMF0, or AMF3 to switch message bodies to AMF3
+	Headers  []Header
+	Messages []Message
+}
+
+// Header is a packet header, such as Credentials or AppendToGatewayUrl.
+type Header struct {
+	Name           string
+	MustUnderstand bool
+	Value          interface{}
+}
+
+// Message is a call or a response in a packet. Calls target a
+// "Service.method" and name the response, usually "/1"; responses target
+// "/1/onResult" or "/1/onStatus" and have "null" as their response URI.
+type Message struct {
+	TargetURI   string
+	ResponseURI string
+	Body        interface{}
+}
+
+// ReadPacket reads a packet from r. Values are decoded by an amf0.Decoder
+// into amf0 wire types, and into amf3 wire types where they switch to AMF3.
+// The byte lengths given before each value are not relied upon, as some
+// clients write 0xFFFFFFFF instead.
+func ReadPacket(r io.Reader) (*Packet, error) {
+	return readPacket(r, amf0.DecoderLimits{})
+}
+
+// readPacket reads a packet, decoding each value within limits.
+func readPacket(r io.Reader, limits amf0.DecoderLimits) (*Packet, error) {
+	br, ok := r.(io.ByteReader)
+	if !ok {
+		b := bufio.NewReader(r)
+		r, br = b, b
+	}
+	pr := &packetReader{r: r, br: br, limits: limits}
+	p := new(Packet)
+	version, err := pr.readUint16()
+	if err != nil {
+		// an empty input has no packet at all
+		return nil, err
+	}
+	p.Version = version
+	headerCount, err := pr.readUint16()
+	if err != nil {
+		return nil, pr.noEOF(err)
+	}
+	for i := 0; i < int(headerCount); i++ {
+		var h Header
+		h.Name, err = pr.readString()
+		if err != nil {
+			return nil, pr.noEOF(err)
+		}
+		mustUnderstand, err := pr.br.ReadByte()
+		if err != nil {
+			return nil, pr.noEOF(err)
+		}
+		h.MustUnderstand = mustUnderstand != 0
+		h.Value, err = pr.readValue()
+		if err != nil {
+			return nil, fmt.Errorf("amf: header %q: %w", h.Name, pr.noEOF(err))
+		}
+		p.Headers = append(p.Headers, h)
+	}
+	messageCount, err := pr.readUint16()
+	if err != nil {
+		return nil, pr.noEOF(err)
+	}
+	for i := 0; i < int(messageCount); i++ {
+		var m Message
+		m.TargetURI, err = pr.readString()
+		if err != nil {
+			return nil, pr.noEOF(err)
+		}
+		m.ResponseURI, err = pr.readString()
+		if err != nil {
+			return nil, pr.noEOF(err)
+		}
+		m.Body, err = pr.readValue()
+		if err != nil {
+			return nil, fmt.Errorf("amf: message %q: %w", m.TargetURI, pr.noEOF(err))
+		}
+		p.Messages = append(p.Messages, m)
+	}
+	return p, nil
+}
+
+type packetReader struct {
+	r       io.Reader
+	br      io.ByteReader
+	limits  amf0.DecoderLimits
+	scratch [4]byte
+}
+
+// noEOF reports the input ending inside a packet as io.ErrUnexpectedEOF.
+func (pr *packetReader) noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (pr *packetReader) readUint16() (uint16, error) {
+	_, err := io.ReadFull(pr.r, pr.scratch[:2])
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint16(pr.scratch[:2]), nil
+}
+
+func (pr *packetReader) readString() (string, error) {
+	n, err := pr.readUint16()
+	if err != nil {
+		return "", err
+	}
+	b := make([]byte, n)
+	_, err = io.ReadFull(pr.r, b)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// readValue reads a value with its length. Each value has reference tables
+// of its own, so each gets a fresh decoder.
+func (pr *packetReader) readValue() (interface{}, error) {
+	_, err := io.ReadFull(pr.r, pr.scratch[:4])
+	if err != nil {
+		return nil, err
+	}
+	dec := amf0.NewDecoder(pr.r)
+	dec.SetLimits(pr.limits)
+	return dec.Decode()
+}
+
+// WritePacket writes p to w. Values are encoded by an amf0.Encoder; when the
+// packet version is AMF3, message bodies other than amf0 wire types are
+// switched to AMF3 with an amf0.AvmPlusObjectType.
+func WritePacket(w io.Writer, p *Packet) error {
+	b, err := AppendPacket(nil, p)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// AppendPacket appends the encoding of p to dst and returns the extended
+// buffer.
+func AppendPacket(dst []byte, p *Packet) ([]byte, error) {
+	if len(p.Headers) > 0xFFFF || len(p.Messages) > 0xFFFF {
+		return dst, errors.New("amf: too many headers or messages in packet")
+	}
+	b := binary.BigEndian.AppendUint16(dst, p.Version)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(p.Headers)))
+	var err error
+	for _, h := range p.Headers {
+		b, err = appendPacketString(b, h.Name)
+		if err != nil {
+			return dst, err
+		}
+		if h.MustUnderstand {
+			b = append(b, 1)
+		} else {
+			b = append(b, 0)
+		}
+		b, err = appendPacketValue(b, h.Value)
+		if err != nil {
+			return dst, fmt.Errorf("amf: header %q: %w", h.Name, err)
+		}
+	}
+	b = binary.BigEndian.AppendUint16(b, uint16(len(p.Messages)))
+	for _, m := range p.Messages {
+		b, err = appendPacketString(b, m.TargetURI)
+		if err != nil {
+			return dst, err
+		}
+		b, err = appendPacketString(b, m.ResponseURI)
+		if err != nil {
+			return dst, err
+		}
+		body := m.Body
+		if p.Version == AMF3 && !isAMF0Body(body) {
+			body = amf0.AvmPlusObjectType{Value: body}
+		}
+		b, err = appendPacketValue(b, body)
+		if err != nil {
+			return dst, fmt.Errorf("amf: message %q: %w", m.TargetURI, err)
+		}
+	}
+	return b, nil
+}
+
+// isAMF0Body reports whether body is already made of amf0 wire types, as the
+// strict array of AVM+ values a Flash request carries, and so is written
+// as it is.
+func isAMF0Body(body interface{}) bool {
+	t := reflect.TypeOf(body)
+	return t != nil && isWireType(t, amf0PkgPath)
+}
+
+func appendPacketString(b []byte, s string) ([]byte, error) {
+	if len(s) > 0xFFFF {
+		return b, errors.New("amf: packet string too long")
+	}
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...), nil
+}
+
+// appendPacketValue appends v after its byte length.
+func appendPacketValue(b []byte, v interface{}) ([]byte, error) {
+	start := len(b)
+	b = append(b, 0, 0, 0, 0)
+	b, err := amf0.AppendEncode(b, v)
+	if err != nil {
+		return b, err
+	}
+	binary.BigEndian.PutUint32(b[start:], uint32(len(b)-start-4))
+	return b, nil
+}
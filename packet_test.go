@@ -0,0 +1,121 @@
+package amf
+
+import (
+	"bytes"
+	"errors"
+	"github.com/hongruiqi/amf.go/amf0"
+	"github.com/hongruiqi/amf.go/amf3"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestWritePacket(t *testing.T) {
+	p := &Packet{
+		Version: AMF0,
+		Headers: []Header{{Name: "h", MustUnderstand: true, Value: amf0.BooleanType(true)}},
+		Messages: []Message{
+			{TargetURI: "S.m", ResponseURI: "/1", Body: &amf0.StrictArrayType{amf0.NumberType(1)}},
+		},
+	}
+	buf := new(bytes.Buffer)
+	err := WritePacket(buf, p)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	expect := []byte{0x00, 0x00, 0x00, 0x01,
+		0x00, 0x01, 'h', 0x01, 0x00, 0x00, 0x00, 0x02, 0x01, 0x01,
+		0x00, 0x01,
+		0x00, 0x03, 'S', '.', 'm', 0x00, 0x02, '/', '1', 0x00, 0x00, 0x00, 0x0e,
+		0x0a, 0x00, 0x00, 0x00, 0x01, 0x00, 0x3f, 0xf0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+	if !bytes.Equal(expect, buf.Bytes()) {
+		t.Errorf("expect %x got %x", expect, buf.Bytes())
+	}
+}
+
+func TestReadPacket(t *testing.T) {
+	for _, version := range []uint16{AMF0, AMF3} {
+		p := &Packet{
+			Version: version,
+			Headers: []Header{{Name: "Credentials", Value: amf0.StringType("secret")}},
+			Messages: []Message{
+				{TargetURI: "Echo.echo", ResponseURI: "/1", Body: []interface{}{"foo"}},
+				{TargetURI: "Echo.echo", ResponseURI: "/2", Body: []interface{}{"bar"}},
+			},
+		}
+		data, err := AppendPacket(nil, p)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		got, err := ReadPacket(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("version %d: %s", version, err)
+		}
+		if got.Version != version || len(got.Headers) != 1 || len(got.Messages) != 2 {
+			t.Fatalf("version %d: got %+v", version, got)
+		}
+		if got.Headers[0] != p.Headers[0] {
+			t.Errorf("version %d: expect header %+v got %+v", version, p.Headers[0], got.Headers[0])
+		}
+		var expect interface{} = &amf0.StrictArrayType{amf0.StringType("bar")}
+		if version == AMF3 {
+			expect = &amf3.ArrayType{Dense: []interface{}{amf3.StringType("bar")}, Associative: map[amf3.StringType]interface{}{}}
+		}
+		m := got.Messages[1]
+		if m.TargetURI != "Echo.echo" || m.ResponseURI != "/2" || !reflect.DeepEqual(m.Body, expect) {
+			t.Errorf("version %d: expect body %#v got %+v", version, expect, m)
+		}
+	}
+}
+
+func TestPacketRoundTripFlash(t *testing.T) {
+	// Flash Player calls Echo.echo("foo") over AMF3 as a strict array
+	// whose items each switch to AMF3 on their own
+	data := []byte{0x00, 0x03, 0x00, 0x00, 0x00, 0x01,
+		0x00, 0x09, 'E', 'c', 'h', 'o', '.', 'e', 'c', 'h', 'o', 0x00, 0x02, '/', '1', 0x00, 0x00, 0x00, 0x0b,
+		0x0a, 0x00, 0x00, 0x00, 0x01, 0x11, 0x06, 0x07, 'f', 'o', 'o'}
+	p, err := ReadPacket(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	buf := new(bytes.Buffer)
+	err = WritePacket(buf, p)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if !bytes.Equal(data, buf.Bytes()) {
+		t.Errorf("expect %x got %x", data, buf.Bytes())
+	}
+}
+
+func TestReadPacketUnknownLength(t *testing.T) {
+	data := []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x01,
+		0x00, 0x01, 'a', 0x00, 0x00, 0xff, 0xff, 0xff, 0xff, 0x02, 0x00, 0x01, 'x'}
+	p, err := ReadPacket(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if len(p.Messages) != 1 || p.Messages[0].Body != amf0.StringType("x") {
+		t.Errorf("got %+v", p)
+	}
+}
+
+func TestReadPacketTruncated(t *testing.T) {
+	data, err := AppendPacket(nil, &Packet{
+		Headers:  []Header{{Name: "h", Value: amf0.NumberType(1)}},
+		Messages: []Message{{TargetURI: "S.m", ResponseURI: "/1", Body: "foo"}},
+	})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	_, err = ReadPacket(bytes.NewReader(nil))
+	if err != io.EOF {
+		t.Errorf("empty input: expect io.EOF got %v", err)
+	}
+	for i := 1; i < len(data); i++ {
+		_, err = ReadPacket(bytes.NewReader(data[:i]))
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("input cut at %d: expect io.ErrUnexpectedEOF got %v", i, err)
+		}
+	}
+}